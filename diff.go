@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/kkohtaka/gosplit/pkg/gosplit"
+	"github.com/spf13/cobra"
+)
+
+// Kinds of changes reported by the diff command.
+const (
+	changeAdded    = "added"
+	changeModified = "modified"
+	changeRemoved  = "removed"
+)
+
+// chunkChange is a chunk that was added, modified or removed between two revisions.
+// Added and modified chunks are taken from the head revision, and removed chunks from the base revision.
+type chunkChange struct {
+	Change string `json:"change"`
+	*gosplit.Chunk
+	PreviousID string `json:"previous_id,omitempty"` // ID of the chunk in the base revision, for modified chunks
+}
+
+// gitFileChange is a Go source file changed between two revisions.
+type gitFileChange struct {
+	Status byte   // 'A' (added), 'D' (deleted), or 'M' (modified)
+	Path   string // The slash-separated path relative to the root of the repository
+}
+
+// git runs a git command in the repository at dir and returns its standard output.
+func git(dir string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("git %s: %v", args[0], err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// gitChangedFiles returns the Go source files that differ between the base and head revisions
+// of the repository at dir. Files skipped by the "./..." pattern, such as those in vendor and
+// testdata directories, are not returned.
+func gitChangedFiles(dir, base, head string) ([]gitFileChange, error) {
+	out, err := git(dir, "diff", "--name-status", "-z", "--no-renames", base, head, "--", "*.go")
+	if err != nil {
+		return nil, fmt.Errorf("error listing changed files: %v", err)
+	}
+
+	// The output consists of NUL-terminated pairs of status and path.
+	fields := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	var changes []gitFileChange
+	for i := 0; i+1 < len(fields); i += 2 {
+		status, path := fields[i], fields[i+1]
+		if !isGoPath(path) {
+			continue
+		}
+		switch status[0] {
+		case 'A', 'D':
+			changes = append(changes, gitFileChange{Status: status[0], Path: path})
+		default:
+			// Type changes and other statuses leave a Go file at both revisions.
+			changes = append(changes, gitFileChange{Status: 'M', Path: path})
+		}
+	}
+	return changes, nil
+}
+
+// isGoPath reports whether the slash-separated path is a Go source file that would be found
+// by the "./..." pattern.
+func isGoPath(path string) bool {
+	elems := strings.Split(path, "/")
+	for _, dir := range elems[:len(elems)-1] {
+		if gosplit.SkipDir(dir) {
+			return false
+		}
+	}
+	return gosplit.IsGoFile(elems[len(elems)-1])
+}
+
+// gitShow returns the content of the file at path in the given revision.
+func gitShow(dir, rev, path string) ([]byte, error) {
+	out, err := git(dir, "show", rev+":"+path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s at %s: %v", path, rev, err)
+	}
+	return out, nil
+}
+
+// revisionChunks returns the chunks of the file at path in the given revision, as split by splitter.
+func revisionChunks(dir, rev, path string, splitter *gosplit.Splitter) ([]*gosplit.Chunk, error) {
+	src, err := gitShow(dir, rev, path)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := splitter.SplitSource(path, src)
+	if err != nil {
+		return nil, fmt.Errorf("error processing %s at %s: %v", path, rev, err)
+	}
+	return chunks, nil
+}
+
+// symbolKey identifies the declaration of a chunk within its file. Declarations without a name,
+// such as var and const blocks, are identified by their type only and matched by position.
+func symbolKey(c *gosplit.Chunk) string {
+	if c.Name == "" {
+		return string(c.Type)
+	}
+	if c.Receiver != "" {
+		return c.Receiver + "." + c.Name
+	}
+	return c.Name
+}
+
+// diffChunks compares the chunks of a file at two revisions by symbol. Chunks whose content is
+// unchanged are omitted. Added and modified chunks are returned in head order, followed by the
+// removed chunks in base order.
+func diffChunks(baseChunks, headChunks []*gosplit.Chunk) []chunkChange {
+	// Match unchanged chunks first, so that declarations sharing a key, such as several init
+	// functions, are paired with the same declaration whenever possible.
+	baseByKey := make(map[string][]*gosplit.Chunk)
+	for _, c := range baseChunks {
+		key := symbolKey(c)
+		baseByKey[key] = append(baseByKey[key], c)
+	}
+	matched := make(map[*gosplit.Chunk]bool)
+	var unmatchedHead []*gosplit.Chunk
+	for _, c := range headChunks {
+		if prev := takeChunk(baseByKey, symbolKey(c), func(b *gosplit.Chunk) bool { return b.ContentHash == c.ContentHash }); prev != nil {
+			matched[prev] = true
+			continue
+		}
+		unmatchedHead = append(unmatchedHead, c)
+	}
+
+	var changes []chunkChange
+	for _, c := range unmatchedHead {
+		if prev := takeChunk(baseByKey, symbolKey(c), func(*gosplit.Chunk) bool { return true }); prev != nil {
+			matched[prev] = true
+			changes = append(changes, chunkChange{Change: changeModified, Chunk: c, PreviousID: prev.ID})
+			continue
+		}
+		changes = append(changes, chunkChange{Change: changeAdded, Chunk: c})
+	}
+	for _, c := range baseChunks {
+		if !matched[c] {
+			changes = append(changes, chunkChange{Change: changeRemoved, Chunk: c})
+		}
+	}
+	return changes
+}
+
+// takeChunk removes and returns the first chunk with the given key that satisfies match, or nil.
+func takeChunk(byKey map[string][]*gosplit.Chunk, key string, match func(*gosplit.Chunk) bool) *gosplit.Chunk {
+	chunks := byKey[key]
+	for i, c := range chunks {
+		if match(c) {
+			byKey[key] = append(chunks[:i:i], chunks[i+1:]...)
+			return c
+		}
+	}
+	return nil
+}
+
+// diffRevisions returns the chunks added, modified and removed between the base and head
+// revisions of the repository at dir. Changed chunks exceeding the chunk size of opts are split,
+// and every part is reported with the change of the original chunk.
+func diffRevisions(dir, base, head string, opts chunkOptions) ([]chunkChange, error) {
+	files, err := gitChangedFiles(dir, base, head)
+	if err != nil {
+		return nil, err
+	}
+	// Chunks are matched before they are split, so that a declaration is matched as a whole.
+	unsplit, err := opts.newSplitter(gosplit.WithChunkSize(0), gosplit.WithChunkOverlap(0))
+	if err != nil {
+		return nil, err
+	}
+	splitter, err := opts.newSplitter()
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []chunkChange
+	for _, file := range files {
+		var baseChunks, headChunks []*gosplit.Chunk
+		if file.Status != 'A' {
+			if baseChunks, err = revisionChunks(dir, base, file.Path, unsplit); err != nil {
+				return nil, err
+			}
+		}
+		if file.Status != 'D' {
+			if headChunks, err = revisionChunks(dir, head, file.Path, unsplit); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, change := range diffChunks(baseChunks, headChunks) {
+			parts, err := splitter.SplitChunk(change.Chunk)
+			if err != nil {
+				return nil, err
+			}
+			for _, part := range parts {
+				changes = append(changes, chunkChange{Change: change.Change, Chunk: part, PreviousID: change.PreviousID})
+			}
+		}
+	}
+	return changes, nil
+}
+
+func runDiff(cmd *cobra.Command, args []string) error {
+	outputFile, _ := cmd.Flags().GetString("output")
+	repo, _ := cmd.Flags().GetString("repo")
+	opts, err := chunkOptionsFromFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	changes, err := diffRevisions(repo, args[0], args[1], opts)
+	if err != nil {
+		return err
+	}
+
+	output, err := createOutput(outputFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = output.Close()
+	}()
+
+	// Write changes as JSON lines
+	encoder := json.NewEncoder(output)
+	for _, change := range changes {
+		if err := encoder.Encode(change); err != nil {
+			return fmt.Errorf("error writing chunk: %v", err)
+		}
+	}
+
+	if outputFile != "" {
+		fmt.Printf("Successfully wrote %d changed chunks to %s\n", len(changes), outputFile)
+	}
+	return nil
+}
+
+func newDiffCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff <base> <head>",
+		Short: "Report the chunks added, modified and removed between two git revisions",
+		Long: `Compare the Go source files of a local git repository at two revisions, and write the chunks
+that were added, modified or removed between them as JSON lines.
+
+Chunks are matched by file and symbol (the receiver and name of the declaration), so that a
+modified declaration is reported once, with the ID it had in the base revision.`,
+		Args: cobra.ExactArgs(2),
+		RunE: runDiff,
+	}
+	cmd.Flags().String("repo", ".", "Directory of the git repository")
+	return cmd
+}
@@ -1,412 +1,316 @@
 // Package main implements a command-line tool that splits Go source code files into chunks,
-// where each chunk contains a function, struct definition, method, constant, or variable.
+// where each chunk contains a function, type definition, method, constant, or variable.
 // The output chunks are intended to be used with embedding models.
+// The chunks are produced by the gosplit library package; see pkg/gosplit.
 package main
 
 import (
 	"encoding/json"
 	"fmt"
-	"go/ast"
-	"go/parser"
-	"go/token"
+	"io"
+	"iter"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 
-	"github.com/pkoukk/tiktoken-go"
+	"github.com/kkohtaka/gosplit/pkg/gosplit"
 	"github.com/spf13/cobra"
 )
 
-// ChunkType represents the type of code chunk that can be extracted from a Go source file.
-type ChunkType string
-
-const (
-	// ChunkTypeFunction represents a standalone function declaration.
-	ChunkTypeFunction ChunkType = "function"
-	// ChunkTypeStruct represents a struct type definition.
-	ChunkTypeStruct ChunkType = "struct"
-	// ChunkTypeMethod represents a method declaration with a receiver.
-	ChunkTypeMethod ChunkType = "method"
-	// ChunkTypeVar represents a variable declaration.
-	ChunkTypeVar ChunkType = "var"
-	// ChunkTypeConst represents a constant declaration.
-	ChunkTypeConst ChunkType = "const"
-
-	// LangGo represents the Go programming language.
-	LangGo = "go"
-)
-
-// Chunk represents a piece of Go source code that has been extracted from a file.
-// It contains metadata about the code such as its type, name, and size in tokens.
-type Chunk struct {
-	Content  string    `json:"content"`            // The actual source code content
-	Type     ChunkType `json:"type"`               // The type of code (function, struct, method, etc.)
-	Name     string    `json:"name,omitempty"`     // The name of the function/struct/method
-	Path     string    `json:"path"`               // The source file path
-	Receiver string    `json:"receiver,omitempty"` // The receiver type for methods
-	Size     int       `json:"size"`               // Number of tokens in the content
-	Lang     string    `json:"lang"`               // The programming language of the chunk
-	Start    int       `json:"start"`              // Starting line number of the content
-	End      int       `json:"end"`                // Ending line number of the content
+// chunkOptions holds the settings that determine the chunks emitted for a file.
+type chunkOptions struct {
+	Tokenizer     gosplit.Tokenizer   // Tokenizer used to count tokens
+	TokenizerFile string              // The BPE rank file or tokenizer.json the tokenizer was loaded from, if any
+	ChunkSize     int                 // Maximum number of tokens per chunk (0 means no limit)
+	Overlap       int                 // Number of trailing tokens of a split part repeated in the next part
+	RepeatHeader  bool                // Whether every part of a split function starts with its header
+	Context       gosplit.ContextMode // Whether and how the file context is included
+	TypeCheck     bool                // Whether packages are type-checked to resolve references
 }
 
-// countTokens counts the number of tokens in the given text using the tiktoken library.
-// It uses the cl100k_base encoding, which is the same encoding used by GPT models.
-// Returns the number of tokens and any error that occurred during counting.
-func countTokens(text string) (int, error) {
-	encoding, err := tiktoken.GetEncoding("cl100k_base")
-	if err != nil {
-		return 0, fmt.Errorf("error getting encoding: %v", err)
-	}
-	return len(encoding.Encode(text, nil, nil)), nil
+// newSplitter returns a splitter configured with opts, followed by the given extra options.
+func (o chunkOptions) newSplitter(extra ...gosplit.Option) (*gosplit.Splitter, error) {
+	return gosplit.New(append([]gosplit.Option{
+		gosplit.WithTokenizer(o.Tokenizer),
+		gosplit.WithChunkSize(o.ChunkSize),
+		gosplit.WithChunkOverlap(o.Overlap),
+		gosplit.WithRepeatHeader(o.RepeatHeader),
+		gosplit.WithContext(o.Context),
+		gosplit.WithTypeCheck(o.TypeCheck),
+	}, extra...)...)
 }
 
-func processFuncDecl(d *ast.FuncDecl, src []byte, fset *token.FileSet) *Chunk {
-	// Get the function name
-	name := d.Name.Name
-
-	// Get the function content including doc strings
-	start := d.Pos()
-	end := d.End()
-
-	if d.Doc != nil {
-		start = min(start, d.Doc.Pos())
-		end = max(end, d.Doc.End())
-	}
-
-	startPos := fset.Position(start)
-	endPos := fset.Position(end)
-	content := string(src[startPos.Offset:endPos.Offset])
-
-	if d.Recv != nil && len(d.Recv.List) > 0 {
-		// This is a method
-		receiverType := getReceiverType(d.Recv.List[0].Type)
-		return &Chunk{
-			Content:  content,
-			Type:     ChunkTypeMethod,
-			Name:     name,
-			Receiver: receiverType,
-			Lang:     LangGo,
-			Start:    startPos.Line,
-			End:      endPos.Line,
+// chunkOptionsFromFlags returns the chunk options selected by the command-line flags.
+func chunkOptionsFromFlags(cmd *cobra.Command) (chunkOptions, error) {
+	chunkSize, _ := cmd.Flags().GetInt("chunk-size")
+	repeatHeader, _ := cmd.Flags().GetBool("repeat-header")
+	chunkOverlap, _ := cmd.Flags().GetInt("chunk-overlap")
+	tokenizerName, _ := cmd.Flags().GetString("tokenizer")
+	bpeFile, _ := cmd.Flags().GetString("bpe-file")
+	tokenizerJSON, _ := cmd.Flags().GetString("tokenizer-json")
+	context, _ := cmd.Flags().GetString("context")
+	var tok gosplit.Tokenizer
+	var err error
+	tokenizerFile := bpeFile
+	if tokenizerJSON != "" {
+		if cmd.Flags().Changed("tokenizer") || bpeFile != "" {
+			return chunkOptions{}, fmt.Errorf("--tokenizer-json cannot be combined with --tokenizer or --bpe-file")
 		}
+		tok, err = gosplit.NewHFTokenizer(tokenizerJSON)
+		tokenizerFile = tokenizerJSON
+	} else {
+		tok, err = gosplit.NewTokenizer(tokenizerName, bpeFile)
 	}
-
-	// This is a standalone function
-	return &Chunk{
-		Content: content,
-		Type:    ChunkTypeFunction,
-		Name:    name,
-		Lang:    LangGo,
-		Start:   startPos.Line,
-		End:     endPos.Line,
+	if err != nil {
+		return chunkOptions{}, err
 	}
-}
-
-func getReceiverType(expr ast.Expr) string {
-	switch t := expr.(type) {
-	case *ast.Ident:
-		return t.Name
-	case *ast.StarExpr:
-		if ident, ok := t.X.(*ast.Ident); ok {
-			return "*" + ident.Name
-		}
+	if chunkOverlap < 0 || (chunkOverlap > 0 && chunkOverlap >= chunkSize) {
+		return chunkOptions{}, fmt.Errorf("--chunk-overlap must be non-negative and smaller than --chunk-size")
+	}
+	if !slices.Contains(gosplit.ContextModes(), gosplit.ContextMode(context)) {
+		return chunkOptions{}, fmt.Errorf("--context must be one of %s", contextModeNames())
 	}
-	return ""
+	return chunkOptions{
+		Tokenizer:     tok,
+		TokenizerFile: tokenizerFile,
+		ChunkSize:     chunkSize,
+		Overlap:       chunkOverlap,
+		RepeatHeader:  repeatHeader,
+		Context:       gosplit.ContextMode(context),
+	}, nil
 }
 
-func processTypeDecl(d *ast.GenDecl, src []byte, fset *token.FileSet) []*Chunk {
-	var chunks []*Chunk
-	for _, spec := range d.Specs {
-		typeSpec, ok := spec.(*ast.TypeSpec)
-		if !ok {
-			continue
-		}
-		_, ok = typeSpec.Type.(*ast.StructType)
-		if !ok {
-			continue
-		}
-
-		name := typeSpec.Name.Name
-		startPos := fset.Position(d.Pos())
-		if d.Doc != nil {
-			startPos = fset.Position(d.Doc.Pos())
-		} else if typeSpec.Doc != nil {
-			startPos = fset.Position(typeSpec.Doc.Pos())
-		}
-		endPos := fset.Position(d.End())
-		content := string(src[startPos.Offset:endPos.Offset])
-
-		chunks = append(chunks, &Chunk{
-			Content: content,
-			Type:    ChunkTypeStruct,
-			Name:    name,
-			Lang:    LangGo,
-			Start:   startPos.Line,
-			End:     endPos.Line,
-		})
+// contextModeNames returns the values of the --context flag separated by commas.
+func contextModeNames() string {
+	var names []string
+	for _, mode := range gosplit.ContextModes() {
+		names = append(names, string(mode))
 	}
-	return chunks
+	return strings.Join(names, ", ")
 }
 
-func processVarConstDecl(d *ast.GenDecl, src []byte, fset *token.FileSet) *Chunk {
-	start := d.Pos()
-	end := d.End()
-
-	if d.Doc != nil {
-		start = min(start, d.Doc.Pos())
-		end = max(end, d.Doc.End())
+// resolveFiles returns the files to be processed for the given command-line arguments.
+// If usePackages is true, the arguments are package patterns resolved with go/packages;
+// otherwise they are file system paths and patterns expanded by gosplit.CollectFiles.
+func resolveFiles(args []string, usePackages bool, bc gosplit.BuildContext) ([]gosplit.File, error) {
+	if usePackages {
+		return gosplit.LoadPackageFiles(args, bc)
 	}
-	if len(d.Specs) > 0 {
-		if v, ok := d.Specs[len(d.Specs)-1].(*ast.ValueSpec); ok {
-			if v.Comment != nil && len(v.Comment.List) > 0 {
-				end = max(end, v.Comment.List[len(v.Comment.List)-1].End())
-			}
-		}
-	}
-	if d.Lparen.IsValid() {
-		start = min(start, d.Lparen)
+
+	paths, err := gosplit.CollectFiles(args)
+	if err != nil {
+		return nil, err
 	}
-	if d.Rparen.IsValid() {
-		end = max(end, d.Rparen)
+	files := make([]gosplit.File, 0, len(paths))
+	for _, path := range paths {
+		files = append(files, gosplit.File{Path: path})
 	}
+	return files, nil
+}
 
-	startPos := fset.Position(start)
-	endPos := fset.Position(end)
-	content := string(src[startPos.Offset:endPos.Offset])
-
-	chunkType := ChunkTypeVar
-	if d.Tok == token.CONST {
-		chunkType = ChunkTypeConst
+// createOutput returns the destination of the JSON lines: the named file, or stdout if outputFile is empty.
+// Closing the returned stdout is a no-op.
+func createOutput(outputFile string) (io.WriteCloser, error) {
+	if outputFile == "" {
+		return nopCloser{os.Stdout}, nil
 	}
-
-	return &Chunk{
-		Content: content,
-		Type:    chunkType,
-		Lang:    LangGo,
-		Start:   startPos.Line,
-		End:     endPos.Line,
+	output, err := os.Create(filepath.Clean(outputFile))
+	if err != nil {
+		return nil, fmt.Errorf("error creating output file: %v", err)
 	}
+	return output, nil
 }
 
-func extractChunks(file *ast.File, src []byte, fset *token.FileSet) []*Chunk {
-	var chunks []*Chunk
+type nopCloser struct {
+	io.Writer
+}
 
-	for _, decl := range file.Decls {
-		switch d := decl.(type) {
-		case *ast.FuncDecl:
-			chunks = append(chunks, processFuncDecl(d, src, fset))
-		case *ast.GenDecl:
-			switch d.Tok {
-			case token.TYPE:
-				chunks = append(chunks, processTypeDecl(d, src, fset)...)
-			case token.VAR, token.CONST:
-				chunks = append(chunks, processVarConstDecl(d, src, fset))
-			}
-		}
-	}
-	return chunks
+func (nopCloser) Close() error { return nil }
+
+// runOptions holds the settings of the root command selected by the command-line flags.
+type runOptions struct {
+	chunkOptions
+	OutputFile string               // Output file for JSON lines, or "" for stdout
+	Root       string               // Directory that chunk paths are made relative to
+	Manifest   string               // State file of previous runs, if any
+	Packages   bool                 // Whether arguments are Go package patterns
+	Build      gosplit.BuildContext // Build configuration used to resolve packages and dependencies
+	Jobs       int                  // Number of files split concurrently
+	Callers    bool                 // Whether the callers of functions and methods are recorded
 }
 
-func processFile(path string) ([]*Chunk, error) {
-	fset := token.NewFileSet()
-	src, err := os.ReadFile(filepath.Clean(path))
+// runOptionsFromFlags returns the settings of the root command selected by the command-line flags.
+func runOptionsFromFlags(cmd *cobra.Command) (runOptions, error) {
+	chunkOpts, err := chunkOptionsFromFlags(cmd)
 	if err != nil {
-		return nil, fmt.Errorf("error reading file: %v", err)
+		return runOptions{}, err
 	}
-
-	file, err := parser.ParseFile(fset, path, src, parser.ParseComments)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing file: %v", err)
+	opts := runOptions{chunkOptions: chunkOpts}
+	opts.OutputFile, _ = cmd.Flags().GetString("output")
+	opts.Root, _ = cmd.Flags().GetString("root")
+	opts.Manifest, _ = cmd.Flags().GetString("manifest")
+	opts.Packages, _ = cmd.Flags().GetBool("packages")
+	opts.Build.Dir = opts.Root
+	opts.Build.Tags, _ = cmd.Flags().GetStringSlice("tags")
+	opts.Build.GOOS, _ = cmd.Flags().GetString("goos")
+	opts.Build.GOARCH, _ = cmd.Flags().GetString("goarch")
+	opts.Build.Tests, _ = cmd.Flags().GetBool("tests")
+	opts.Jobs, _ = cmd.Flags().GetInt("jobs")
+	if opts.Jobs <= 0 {
+		opts.Jobs = runtime.NumCPU()
 	}
-
-	return extractChunks(file, src, fset), nil
+	opts.Callers, _ = cmd.Flags().GetBool("callers")
+	opts.TypeCheck, _ = cmd.Flags().GetBool("typecheck")
+	if opts.Callers && opts.Manifest != "" {
+		// The callers of unchanged chunks, which are not written again, may have changed.
+		return runOptions{}, fmt.Errorf("--callers cannot be combined with --manifest")
+	}
+	if opts.TypeCheck && opts.Manifest != "" {
+		// The signatures and references of unchanged chunks, which are not written again,
+		// may have changed with the other files of their packages.
+		return runOptions{}, fmt.Errorf("--typecheck cannot be combined with --manifest")
+	}
+	return opts, nil
 }
 
-func splitChunk(chunk *Chunk, maxTokens int) ([]*Chunk, error) {
-	// If maxTokens is 0 or negative, return the original chunk
-	if maxTokens <= 0 {
-		return []*Chunk{chunk}, nil
+func run(cmd *cobra.Command, args []string) error {
+	opts, err := runOptionsFromFlags(cmd)
+	if err != nil {
+		return err
 	}
 
-	// Count tokens in the content
-	tokenCount, err := countTokens(chunk.Content)
+	files, err := resolveFiles(args, opts.Packages, opts.Build)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("error collecting files: %v", err)
 	}
-
-	// If content is within limit, return as is
-	if tokenCount <= maxTokens {
-		return []*Chunk{chunk}, nil
+	root := opts.Root
+	if opts.Packages && root == "" {
+		// Files resolved from packages have absolute paths; make them relative
+		// to the directory the packages were resolved from.
+		root = "."
 	}
 
-	// Split content into lines
-	lines := strings.Split(chunk.Content, "\n")
-	var chunks []*Chunk
-	var currentChunk strings.Builder
-	currentTokenCount := 0
+	splitter, err := opts.newSplitter(gosplit.WithRoot(root), gosplit.WithJobs(opts.Jobs),
+		gosplit.WithCallers(opts.Callers), gosplit.WithBuildContext(opts.Build))
+	if err != nil {
+		return err
+	}
 
-	for _, line := range lines {
-		lineTokenCount, err := countTokens(line)
+	// With a manifest, only the files that changed since the previous run are split.
+	var updater *manifestUpdater
+	if opts.Manifest != "" {
+		updater, err = newManifestUpdater(opts.Manifest, opts.chunkOptions)
 		if err != nil {
-			return nil, err
+			return err
 		}
-
-		// If a single line exceeds the limit, we need to split it
-		if lineTokenCount > maxTokens {
-			// If we have accumulated content, create a chunk for it
-			if currentChunk.Len() > 0 {
-				newChunk := chunk
-				newChunk.Content = currentChunk.String()
-				newChunk.Size = currentTokenCount
-				chunks = append(chunks, newChunk)
-				currentChunk.Reset()
-				currentTokenCount = 0
-			}
-
-			// Split the long line into smaller chunks
-			words := strings.Fields(line)
-			var lineChunk strings.Builder
-			lineTokenCount = 0
-
-			for _, word := range words {
-				wordTokenCount, err := countTokens(word)
-				if err != nil {
-					return nil, err
-				}
-
-				if lineTokenCount+wordTokenCount > maxTokens {
-					if lineChunk.Len() > 0 {
-						newChunk := chunk
-						newChunk.Content = lineChunk.String()
-						newChunk.Size = lineTokenCount
-						chunks = append(chunks, newChunk)
-						lineChunk.Reset()
-						lineTokenCount = 0
-					}
-				}
-
-				if lineChunk.Len() > 0 {
-					lineChunk.WriteString(" ")
-				}
-				lineChunk.WriteString(word)
-				lineTokenCount += wordTokenCount
-			}
-
-			if lineChunk.Len() > 0 {
-				newChunk := chunk
-				newChunk.Content = lineChunk.String()
-				newChunk.Size = lineTokenCount
-				chunks = append(chunks, newChunk)
-			}
-			continue
-		}
-
-		// If adding this line would exceed the limit, create a new chunk
-		if currentTokenCount+lineTokenCount > maxTokens {
-			newChunk := chunk
-			newChunk.Content = currentChunk.String()
-			newChunk.Size = currentTokenCount
-			chunks = append(chunks, newChunk)
-			currentChunk.Reset()
-			currentTokenCount = 0
-		}
-
-		// Add the line to the current chunk
-		if currentChunk.Len() > 0 {
-			currentChunk.WriteString("\n")
+		files, err = updater.changedFiles(files, splitter)
+		if err != nil {
+			return err
 		}
-		currentChunk.WriteString(line)
-		currentTokenCount += lineTokenCount
 	}
 
-	// Add the last chunk if there's any content
-	if currentChunk.Len() > 0 {
-		newChunk := chunk
-		newChunk.Content = currentChunk.String()
-		newChunk.Size = currentTokenCount
-		chunks = append(chunks, newChunk)
+	output, err := createOutput(opts.OutputFile)
+	if err != nil {
+		return err
 	}
+	defer func() {
+		_ = output.Close()
+	}()
 
-	return chunks, nil
-}
-
-func run(cmd *cobra.Command, args []string) error {
-	inputFile := args[0]
-	outputFile, _ := cmd.Flags().GetString("output")
-	chunkSize, _ := cmd.Flags().GetInt("chunk-size")
-
-	chunks, err := processFile(inputFile)
+	written, deleted, err := writeChunks(output, splitter.SplitFilesSeq(files), updater)
 	if err != nil {
-		return fmt.Errorf("error processing file: %v", err)
+		return err
 	}
-
-	// Count tokens for each chunk
-	for i := range chunks {
-		tokenCount, err := countTokens(chunks[i].Content)
-		if err != nil {
-			// If token counting fails, set size to 0
-			tokenCount = 0
+	if updater != nil {
+		if err := updater.save(); err != nil {
+			return err
 		}
-		chunks[i].Size = tokenCount
 	}
 
-	// Split chunks based on token count if chunk size is specified
-	if chunkSize > 0 {
-		var splitChunks []*Chunk
-		for _, chunk := range chunks {
-			split, err := splitChunk(chunk, chunkSize)
-			if err != nil {
-				return fmt.Errorf("error splitting chunk: %v", err)
-			}
-			splitChunks = append(splitChunks, split...)
+	if opts.OutputFile != "" {
+		if updater != nil {
+			fmt.Printf("Successfully wrote %d chunks and %d deleted chunks to %s\n", written, deleted, opts.OutputFile)
+		} else {
+			fmt.Printf("Successfully wrote %d chunks to %s\n", written, opts.OutputFile)
 		}
-		chunks = splitChunks
 	}
+	return nil
+}
 
-	// Determine output destination
-	var output *os.File
-	if outputFile == "" {
-		output = os.Stdout
-	} else {
-		var err error
-		output, err = os.Create(filepath.Clean(outputFile))
+// writeChunks writes chunks as JSON lines as soon as they are produced, and returns the number of
+// chunks and tombstones written. With a manifest updater, only the chunks that did not exist in
+// the previous run are written, followed by tombstones for the chunks that no longer exist.
+func writeChunks(w io.Writer, chunks iter.Seq2[*gosplit.Chunk, error], updater *manifestUpdater) (int, int, error) {
+	encoder := json.NewEncoder(w)
+	written := 0
+	for chunk, err := range chunks {
 		if err != nil {
-			return fmt.Errorf("error creating output file: %v", err)
+			return 0, 0, err
+		}
+		if updater != nil && !updater.add(chunk) {
+			continue
 		}
-		defer func() {
-			_ = output.Close()
-		}()
-	}
-
-	// Write chunks as JSON lines
-	encoder := json.NewEncoder(output)
-	for _, chunk := range chunks {
-		chunk.Path = inputFile
 		if err := encoder.Encode(chunk); err != nil {
-			return fmt.Errorf("error writing chunk: %v", err)
+			return 0, 0, fmt.Errorf("error writing chunk: %v", err)
 		}
+		written++
 	}
 
-	if outputFile != "" {
-		fmt.Printf("Successfully wrote %d chunks to %s\n", len(chunks), outputFile)
+	if updater == nil {
+		return written, 0, nil
 	}
-	return nil
+	deleted := updater.deleted()
+	for _, t := range deleted {
+		if err := encoder.Encode(t); err != nil {
+			return 0, 0, fmt.Errorf("error writing deleted chunk: %v", err)
+		}
+	}
+	return written, len(deleted), nil
 }
 
 func main() {
 	rootCmd := &cobra.Command{
-		Use:   "gosplit <input_file.go>",
+		Use:   "gosplit <path>...",
 		Short: "Split Go source code files into chunks for embedding models",
 		Long: `Split Go source code files into chunks, where each chunk contains a function or struct definition.
-The output chunks are intended to be used with embedding models.`,
-		Args:    cobra.ExactArgs(1),
+The output chunks are intended to be used with embedding models.
+
+Each path can be a Go source file, a directory, a directory followed by "/..." to include
+its subdirectories recursively (e.g. "./..."), or a glob pattern.
+
+With --packages, the arguments are Go package patterns resolved by the go command from the
+--root directory (default: the current directory), and only files that are part of the build
+for the selected build tags, GOOS and GOARCH are processed.`,
+		Args:    cobra.MinimumNArgs(1),
 		RunE:    run,
 		Version: "1.0.0",
 	}
 
-	rootCmd.Flags().StringP("output", "o", "", "Output file for JSON lines (default: stdout)")
-	rootCmd.Flags().Int("chunk-size", 0, "Maximum number of tokens per chunk (0 means no limit)")
+	rootCmd.PersistentFlags().StringP("output", "o", "", "Output file for JSON lines (default: stdout)")
+	rootCmd.PersistentFlags().Int("chunk-size", 0, "Maximum number of tokens per chunk (0 means no limit)")
+	rootCmd.PersistentFlags().String("tokenizer", gosplit.DefaultTokenizer,
+		"Tokenizer used to count tokens: "+strings.Join(gosplit.TokenizerNames(), ", "))
+	rootCmd.PersistentFlags().String("tokenizer-json", "", "HuggingFace tokenizer.json file used to count tokens instead of --tokenizer")
+	rootCmd.PersistentFlags().String("bpe-file", "", "Local BPE rank file (.tiktoken) of the tiktoken encoding selected with --tokenizer")
+	rootCmd.PersistentFlags().Int("chunk-overlap", 0, "Number of trailing tokens of a split part repeated at the start of the next part")
+	rootCmd.PersistentFlags().Bool("repeat-header", false, "Start every part of a split function with its declaration header")
+	rootCmd.PersistentFlags().String("context", string(gosplit.ContextNone),
+		"Include the package clause and referenced imports of chunks: "+contextModeNames())
+	rootCmd.Flags().String("manifest", "", "State file of previous runs; only added and changed chunks and deleted chunk IDs are written")
+	rootCmd.Flags().String("root", "", "Directory that chunk paths are made relative to (default: paths as given)")
+	rootCmd.Flags().Bool("packages", false, "Treat arguments as Go package patterns and honor build constraints")
+	rootCmd.Flags().StringSlice("tags", nil, "Build tags to satisfy when resolving packages (with --packages) or their dependencies (with --typecheck)")
+	rootCmd.Flags().String("goos", "", "Target operating system when resolving packages (with --packages) or their dependencies (with --typecheck)")
+	rootCmd.Flags().String("goarch", "", "Target architecture when resolving packages (with --packages) or their dependencies (with --typecheck)")
+	rootCmd.Flags().Bool("tests", false, "Include test files when resolving packages (with --packages)")
+	rootCmd.Flags().IntP("jobs", "j", 0, "Number of files split concurrently (default: the number of CPUs)")
+	rootCmd.Flags().Bool("typecheck", false, "Type-check packages to record resolved signatures, receiver types and references")
+	rootCmd.Flags().Bool("callers", false, "Record the chunk IDs of the callers of functions and methods; chunks are written after all files are split")
+
+	rootCmd.AddCommand(newDiffCmd())
+	rootCmd.CompletionOptions.DisableDefaultCmd = true
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
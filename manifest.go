@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/kkohtaka/gosplit/pkg/gosplit"
+)
+
+// manifest records the state of the files processed by a previous run, so that the next run
+// only emits the chunks that changed since then.
+type manifest struct {
+	Settings string                  `json:"settings"` // Fingerprint of the options the chunks were produced with
+	Files    map[string]manifestFile `json:"files"`    // Processed files keyed by chunk path
+}
+
+// manifestFile is the recorded state of a single file.
+type manifestFile struct {
+	ModTime  time.Time `json:"mod_time"`
+	Size     int64     `json:"size"`
+	Hash     string    `json:"hash"`      // SHA-256 of the file content
+	ChunkIDs []string  `json:"chunk_ids"` // IDs of the chunks emitted for the file
+}
+
+// tombstone is written in place of a chunk that was emitted by a previous run but no longer exists.
+type tombstone struct {
+	ID      string `json:"id"`
+	Path    string `json:"path"`
+	Deleted bool   `json:"deleted"`
+}
+
+// manifestUpdater compares the files of the current run with a manifest loaded from disk
+// and builds the manifest to be saved for the next run.
+type manifestUpdater struct {
+	path  string
+	prev  *manifest
+	next  *manifest
+	reuse bool // Whether the chunks recorded in prev were produced with the current options
+
+	changed []string                   // Paths of the changed files in the order they were checked
+	prevIDs map[string]map[string]bool // IDs of the chunks recorded in prev for each changed file
+}
+
+// newManifestUpdater loads the manifest at path. A missing file is treated as an empty manifest,
+// so that the first run emits all chunks.
+func newManifestUpdater(path string, opts chunkOptions) (*manifestUpdater, error) {
+	prev, err := loadManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	settings := fmt.Sprintf("tokenizer=%s,chunk-size=%d,chunk-overlap=%d,repeat-header=%t,context=%s",
+		opts.Tokenizer.Name(), opts.ChunkSize, opts.Overlap, opts.RepeatHeader, opts.Context)
+	if opts.TokenizerFile != "" {
+		// The name of a tokenizer does not tell which ranks or vocabulary it was loaded from.
+		src, err := os.ReadFile(filepath.Clean(opts.TokenizerFile))
+		if err != nil {
+			return nil, fmt.Errorf("error reading tokenizer file: %v", err)
+		}
+		sum := sha256.Sum256(src)
+		settings += ",tokenizer-file=" + hex.EncodeToString(sum[:])
+	}
+	return &manifestUpdater{
+		path:  path,
+		prev:  prev,
+		next:  &manifest{Settings: settings, Files: make(map[string]manifestFile)},
+		reuse: prev.Settings == settings,
+
+		prevIDs: make(map[string]map[string]bool),
+	}, nil
+}
+
+func loadManifest(path string) (*manifest, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return &manifest{Files: make(map[string]manifestFile)}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest: %v", err)
+	}
+
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("error parsing manifest: %v", err)
+	}
+	if m.Files == nil {
+		m.Files = make(map[string]manifestFile)
+	}
+	return &m, nil
+}
+
+// check reports whether the file at diskPath, whose chunks are recorded under path, changed since
+// the previous run. The recorded chunks of unchanged files are kept, while all chunks of changed
+// files must be passed to add.
+func (u *manifestUpdater) check(path, diskPath string) (bool, error) {
+	info, err := os.Stat(diskPath)
+	if err != nil {
+		return false, fmt.Errorf("error reading file: %v", err)
+	}
+	prev, known := u.prev.Files[path]
+	if known && u.reuse && prev.ModTime.Equal(info.ModTime()) && prev.Size == info.Size() {
+		u.next.Files[path] = prev
+		return false, nil
+	}
+
+	src, err := os.ReadFile(filepath.Clean(diskPath))
+	if err != nil {
+		return false, fmt.Errorf("error reading file: %v", err)
+	}
+	sum := sha256.Sum256(src)
+	hash := hex.EncodeToString(sum[:])
+	if known && u.reuse && prev.Hash == hash {
+		// Only the modification time changed.
+		prev.ModTime = info.ModTime()
+		prev.Size = info.Size()
+		u.next.Files[path] = prev
+		return false, nil
+	}
+
+	// Chunks produced with other options are emitted again, even if their IDs did not change,
+	// since their other fields, such as the size, may have.
+	prevIDs := make(map[string]bool, len(prev.ChunkIDs))
+	if u.reuse {
+		for _, id := range prev.ChunkIDs {
+			prevIDs[id] = true
+		}
+	}
+	u.prevIDs[path] = prevIDs
+	u.changed = append(u.changed, path)
+	u.next.Files[path] = manifestFile{
+		ModTime:  info.ModTime(),
+		Size:     info.Size(),
+		Hash:     hash,
+		ChunkIDs: []string{},
+	}
+	return true, nil
+}
+
+// changedFiles checks the given files, with chunk paths as returned by splitter, and returns those
+// that changed since the previous run.
+func (u *manifestUpdater) changedFiles(files []gosplit.File, splitter *gosplit.Splitter) ([]gosplit.File, error) {
+	var changed []gosplit.File
+	for _, file := range files {
+		path, err := splitter.Path(file.Path)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := u.check(path, file.Path)
+		if err != nil {
+			return nil, fmt.Errorf("error processing file %s: %v", file.Path, err)
+		}
+		if ok {
+			changed = append(changed, file)
+		}
+	}
+	return changed, nil
+}
+
+// add records a chunk of a changed file and reports whether it did not exist in the previous run.
+func (u *manifestUpdater) add(chunk *gosplit.Chunk) bool {
+	file := u.next.Files[chunk.Path]
+	file.ChunkIDs = append(file.ChunkIDs, chunk.ID)
+	u.next.Files[chunk.Path] = file
+	return !u.prevIDs[chunk.Path][chunk.ID]
+}
+
+// deleted returns tombstones for the chunks recorded in the previous run that no longer exist:
+// the chunks removed from the changed files in the order they were checked, followed by the
+// chunks of the files that were not processed by the current run. It must be called after all
+// chunks of the changed files are added.
+func (u *manifestUpdater) deleted() []tombstone {
+	var deleted []tombstone
+	for _, path := range u.changed {
+		current := make(map[string]bool)
+		for _, id := range u.next.Files[path].ChunkIDs {
+			current[id] = true
+		}
+		for _, id := range u.prev.Files[path].ChunkIDs {
+			if !current[id] {
+				deleted = append(deleted, tombstone{ID: id, Path: path, Deleted: true})
+			}
+		}
+	}
+
+	var removed []string
+	for path := range u.prev.Files {
+		if _, ok := u.next.Files[path]; !ok {
+			removed = append(removed, path)
+		}
+	}
+	sort.Strings(removed)
+	for _, path := range removed {
+		for _, id := range u.prev.Files[path].ChunkIDs {
+			deleted = append(deleted, tombstone{ID: id, Path: path, Deleted: true})
+		}
+	}
+	return deleted
+}
+
+// save writes the manifest of the current run, replacing the previous one.
+func (u *manifestUpdater) save() error {
+	data, err := json.MarshalIndent(u.next, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding manifest: %v", err)
+	}
+
+	tmp := u.path + ".tmp"
+	if err := os.WriteFile(filepath.Clean(tmp), append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("error writing manifest: %v", err)
+	}
+	if err := os.Rename(tmp, u.path); err != nil {
+		return fmt.Errorf("error writing manifest: %v", err)
+	}
+	return nil
+}
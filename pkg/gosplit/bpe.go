@@ -0,0 +1,230 @@
+package gosplit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1" // #nosec G505 -- only used to locate files in the tiktoken cache
+	"embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// embeddedEncodings holds the rank files bundled into the binary, named <encoding>.tiktoken.
+//
+//go:embed encodings
+var embeddedEncodings embed.FS
+
+// bpeLoader loads the BPE ranks of tiktoken encodings without network access.
+// The ranks are read from the first of the following that exists: the local file given to
+// the loader, a rank file embedded into the binary, and a file downloaded by an earlier
+// version of gosplit into the tiktoken cache directory.
+type bpeLoader struct {
+	file string // Local rank file, if any
+}
+
+// LoadTiktokenBpe implements tiktoken.BpeLoader. The base name of the URL of the rank file
+// identifies the encoding.
+func (l *bpeLoader) LoadTiktokenBpe(url string) (map[string]int, error) {
+	encoding := strings.TrimSuffix(path.Base(url), ".tiktoken")
+
+	if l.file != "" {
+		data, err := os.ReadFile(filepath.Clean(l.file))
+		if err != nil {
+			return nil, fmt.Errorf("error reading BPE file: %v", err)
+		}
+		return parseBpeRanks(data)
+	}
+
+	data, err := embeddedEncodings.ReadFile("encodings/" + encoding + ".tiktoken")
+	if err == nil {
+		return parseBpeRanks(data)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("error reading embedded BPE ranks: %v", err)
+	}
+
+	data, err = os.ReadFile(tiktokenCachePath(url))
+	if err == nil {
+		return parseBpeRanks(data)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("error reading cached BPE ranks: %v", err)
+	}
+	return nil, fmt.Errorf("no BPE ranks available for encoding %s: download %s and pass it with --bpe-file", encoding, url)
+}
+
+// tiktokenCachePath returns the path at which the tiktoken library caches the file downloaded from url.
+func tiktokenCachePath(url string) string {
+	dir := os.Getenv("TIKTOKEN_CACHE_DIR")
+	if dir == "" {
+		dir = os.Getenv("DATA_GYM_CACHE_DIR")
+	}
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "data-gym-cache")
+	}
+	// #nosec G401 -- the cache key is defined by the tiktoken library
+	return filepath.Join(dir, fmt.Sprintf("%x", sha1.Sum([]byte(url))))
+}
+
+// parseBpeRanks parses a tiktoken rank file, which has a base64-encoded token and its rank per line.
+func parseBpeRanks(data []byte) (map[string]int, error) {
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		encoded, rank, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid BPE rank at line %d", lineNum)
+		}
+		token, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BPE token at line %d: %v", lineNum, err)
+		}
+		r, err := strconv.Atoi(rank)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BPE rank at line %d: %v", lineNum, err)
+		}
+		ranks[string(token)] = r
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading BPE ranks: %v", err)
+	}
+	if len(ranks) == 0 {
+		return nil, fmt.Errorf("no BPE ranks found")
+	}
+	return ranks, nil
+}
+
+// encodingSpec describes a tiktoken encoding as defined by the tiktoken library: the URL at
+// which its ranks are published, the pattern that splits text into pieces, and its special tokens.
+type encodingSpec struct {
+	url     string
+	pattern string
+	special map[string]int
+}
+
+// Patterns of the tiktoken encodings.
+const (
+	patternR50k = `'s|'t|'re|'ve|'m|'ll|'d| ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+|\s+(?!\S)|\s+`
+
+	patternCl100k = `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`
+
+	patternO200k = `[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+		`|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+		`|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+(?!\S)|\s+`
+)
+
+// getEncodingSpec returns the description of the tiktoken encoding with the given name.
+func getEncodingSpec(name string) (encodingSpec, error) {
+	const baseURL = "https://openaipublic.blob.core.windows.net/encodings/"
+	switch name {
+	case tiktoken.MODEL_CL100K_BASE:
+		return encodingSpec{
+			url:     baseURL + "cl100k_base.tiktoken",
+			pattern: patternCl100k,
+			special: map[string]int{
+				tiktoken.ENDOFTEXT:   100257,
+				tiktoken.FIM_PREFIX:  100258,
+				tiktoken.FIM_MIDDLE:  100259,
+				tiktoken.FIM_SUFFIX:  100260,
+				tiktoken.ENDOFPROMPT: 100276,
+			},
+		}, nil
+	case tiktoken.MODEL_O200K_BASE:
+		return encodingSpec{
+			url:     baseURL + "o200k_base.tiktoken",
+			pattern: patternO200k,
+			special: map[string]int{tiktoken.ENDOFTEXT: 199999, tiktoken.ENDOFPROMPT: 200018},
+		}, nil
+	case tiktoken.MODEL_P50K_BASE:
+		return encodingSpec{
+			url:     baseURL + "p50k_base.tiktoken",
+			pattern: patternR50k,
+			special: map[string]int{tiktoken.ENDOFTEXT: 50256},
+		}, nil
+	case tiktoken.MODEL_P50K_EDIT:
+		return encodingSpec{
+			url:     baseURL + "p50k_base.tiktoken",
+			pattern: patternR50k,
+			special: map[string]int{
+				tiktoken.ENDOFTEXT:  50256,
+				tiktoken.FIM_PREFIX: 50281,
+				tiktoken.FIM_MIDDLE: 50282,
+				tiktoken.FIM_SUFFIX: 50283,
+			},
+		}, nil
+	case tiktoken.MODEL_R50K_BASE:
+		return encodingSpec{
+			url:     baseURL + "r50k_base.tiktoken",
+			pattern: patternR50k,
+			special: map[string]int{tiktoken.ENDOFTEXT: 50256},
+		}, nil
+	}
+	return encodingSpec{}, fmt.Errorf("unknown encoding %s", name)
+}
+
+// encodingKey identifies an encoding built by getEncoding.
+type encodingKey struct {
+	name    string
+	bpeFile string
+}
+
+// encodingCache holds the encodings built by getEncoding, so that the ranks of an encoding
+// are only parsed once per process.
+type encodingCache struct {
+	mu        sync.Mutex
+	encodings map[encodingKey]*tiktoken.Tiktoken
+}
+
+//nolint:gochecknoglobals // process-wide cache of immutable encodings
+var encodings = &encodingCache{encodings: make(map[encodingKey]*tiktoken.Tiktoken)}
+
+// getEncoding returns the tiktoken encoding with the given name, loading its ranks without
+// network access. If bpeFile is not empty, the ranks are read from it. Encodings are cached
+// by name and BPE file, and it is safe for concurrent use.
+func getEncoding(name, bpeFile string) (*tiktoken.Tiktoken, error) {
+	key := encodingKey{name: name, bpeFile: bpeFile}
+	encodings.mu.Lock()
+	defer encodings.mu.Unlock()
+	if encoding, ok := encodings.encodings[key]; ok {
+		return encoding, nil
+	}
+
+	spec, err := getEncodingSpec(name)
+	if err != nil {
+		return nil, err
+	}
+	ranks, err := (&bpeLoader{file: bpeFile}).LoadTiktokenBpe(spec.url)
+	if err != nil {
+		return nil, fmt.Errorf("error loading encoding %s: %v", name, err)
+	}
+	bpe, err := tiktoken.NewCoreBPE(ranks, spec.special, spec.pattern)
+	if err != nil {
+		return nil, fmt.Errorf("error loading encoding %s: %v", name, err)
+	}
+	specialTokens := make(map[string]any, len(spec.special))
+	for token := range spec.special {
+		specialTokens[token] = true
+	}
+	encoding := tiktoken.NewTiktoken(bpe, &tiktoken.Encoding{
+		Name:           name,
+		PatStr:         spec.pattern,
+		MergeableRanks: ranks,
+		SpecialTokens:  spec.special,
+	}, specialTokens)
+	encodings.encodings[key] = encoding
+	return encoding, nil
+}
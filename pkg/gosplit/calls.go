@@ -0,0 +1,170 @@
+package gosplit
+
+import (
+	"go/ast"
+	"go/types"
+	"path/filepath"
+)
+
+// getCalls returns the names of the functions and methods called in body as they are written,
+// e.g. "helper", "fmt.Println" or "s.store.Get", in the order of their first call.
+// Calls of builtin functions and conversions to predeclared types are left out. Without type
+// information, conversions to other named types, such as "time.Duration(n)", are taken for calls.
+func getCalls(body *ast.BlockStmt) []string {
+	if body == nil {
+		return nil
+	}
+	var calls []string
+	seen := make(map[string]bool)
+	ast.Inspect(body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		if name := callName(call.Fun); name != "" && !seen[name] {
+			seen[name] = true
+			calls = append(calls, name)
+		}
+		return true
+	})
+	return calls
+}
+
+// callName returns the name of the function called by a call expression with the function fun,
+// without type arguments. It returns "" for predeclared identifiers and for function literals and
+// other expressions without a name.
+func callName(fun ast.Expr) string {
+	switch f := unwrapCallFun(fun).(type) {
+	case *ast.Ident:
+		if types.Universe.Lookup(f.Name) != nil {
+			return ""
+		}
+		return f.Name
+	case *ast.SelectorExpr:
+		return types.ExprString(f)
+	}
+	return ""
+}
+
+// callee is a function or method called by a chunk.
+type callee struct {
+	pkg    string // The import path of the package of a qualified call, or "" for the package of the caller
+	method bool   // Whether a method is called
+	name   string // The name of the function or method
+	object string // The full name of the function or method resolved by type checking, if any
+}
+
+// callees returns the functions and methods called by the function declaration node.
+// A selector is taken for a call of a function of an imported package if its operand is the
+// name of an import of the file, and for a method call otherwise; methods are only known by name
+// unless the file was type-checked.
+func (f *parsedFile) callees(node ast.Node) []callee {
+	decl, ok := node.(*ast.FuncDecl)
+	if !ok || decl.Body == nil {
+		return nil
+	}
+
+	imports := make(map[string]string)
+	for _, spec := range f.file.Imports {
+		imports[importName(spec)] = importPath(spec)
+	}
+
+	var callees []callee
+	seen := make(map[callee]bool)
+	ast.Inspect(decl.Body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		var (
+			c     callee
+			ident *ast.Ident
+		)
+		switch fun := unwrapCallFun(call.Fun).(type) {
+		case *ast.Ident:
+			if types.Universe.Lookup(fun.Name) != nil {
+				return true
+			}
+			c, ident = callee{name: fun.Name}, fun
+		case *ast.SelectorExpr:
+			c, ident = callee{method: true, name: fun.Sel.Name}, fun.Sel
+			if x, ok := fun.X.(*ast.Ident); ok {
+				if path, ok := imports[x.Name]; ok {
+					c = callee{pkg: path, name: fun.Sel.Name}
+				}
+			}
+		default:
+			return true
+		}
+		if f.info != nil {
+			if fn, ok := f.info.Uses[ident].(*types.Func); ok {
+				c.object = fn.Origin().FullName()
+			}
+		}
+		if !seen[c] {
+			seen[c] = true
+			callees = append(callees, c)
+		}
+		return true
+	})
+	return callees
+}
+
+// unwrapCallFun returns the function of a call expression without parentheses and type arguments.
+func unwrapCallFun(fun ast.Expr) ast.Expr {
+	for {
+		switch f := fun.(type) {
+		case *ast.ParenExpr:
+			fun = f.X
+		case *ast.IndexExpr:
+			fun = f.X
+		case *ast.IndexListExpr:
+			fun = f.X
+		default:
+			return fun
+		}
+	}
+}
+
+// linkCallers sets the CalledBy field of the function and method chunks called by the given
+// chunks, which must have their callees set. A callee resolved by type checking is matched by
+// its full name. Otherwise, a function is matched by the package of the caller or of the
+// qualified call, and a method by the package of the caller and its name only, so that all
+// methods of that name are matched. The package of a chunk is its import path, or the directory
+// of its path if the import path is unknown, so that calls of functions of imported packages are
+// only matched between chunks with import paths.
+func linkCallers(chunks []*Chunk) {
+	targets := make(map[callee][]*Chunk)
+	for _, chunk := range chunks {
+		switch chunk.Type {
+		case ChunkTypeFunction, ChunkTypeMethod:
+			c := callee{pkg: chunkPackage(chunk), method: chunk.Type == ChunkTypeMethod, name: chunk.Name}
+			targets[c] = append(targets[c], chunk)
+			if chunk.object != "" {
+				c = callee{object: chunk.object}
+				targets[c] = append(targets[c], chunk)
+			}
+		}
+	}
+
+	for _, caller := range chunks {
+		for _, c := range caller.callees {
+			if c.object != "" {
+				c = callee{object: c.object}
+			} else if c.pkg == "" {
+				c.pkg = chunkPackage(caller)
+			}
+			for _, target := range targets[c] {
+				target.CalledBy = append(target.CalledBy, caller.ID)
+			}
+		}
+	}
+}
+
+// chunkPackage returns the import path of the package of chunk, or the directory of its path.
+func chunkPackage(chunk *Chunk) string {
+	if chunk.ImportPath != "" {
+		return chunk.ImportPath
+	}
+	return filepath.Dir(chunk.Path)
+}
@@ -0,0 +1,445 @@
+package gosplit
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"iter"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ChunkType represents the type of code chunk that can be extracted from a Go source file.
+type ChunkType string
+
+const (
+	// ChunkTypeFunction represents a standalone function declaration.
+	ChunkTypeFunction ChunkType = "function"
+	// ChunkTypeStruct represents a struct type definition.
+	ChunkTypeStruct ChunkType = "struct"
+	// ChunkTypeInterface represents an interface type definition.
+	ChunkTypeInterface ChunkType = "interface"
+	// ChunkTypeConstraint represents an interface type definition with type set elements,
+	// such as "~int | ~float64", which can only be used as a type constraint.
+	ChunkTypeConstraint ChunkType = "constraint"
+	// ChunkTypeType represents any other named type definition, such as "type Status int".
+	ChunkTypeType ChunkType = "type"
+	// ChunkTypeAlias represents a type alias declaration, such as "type A = B".
+	ChunkTypeAlias ChunkType = "alias"
+	// ChunkTypeFuncType represents a function type definition, such as "type HandlerFunc func()".
+	ChunkTypeFuncType ChunkType = "functype"
+	// ChunkTypeMethod represents a method declaration with a receiver.
+	ChunkTypeMethod ChunkType = "method"
+	// ChunkTypeVar represents a variable declaration.
+	ChunkTypeVar ChunkType = "var"
+	// ChunkTypeConst represents a constant declaration.
+	ChunkTypeConst ChunkType = "const"
+
+	// LangGo represents the Go programming language.
+	LangGo = "go"
+)
+
+// Chunk represents a piece of Go source code that has been extracted from a file.
+// It contains metadata about the code such as its type, name, and size in tokens.
+type Chunk struct {
+	ID           string    `json:"id,omitempty"`            // Stable identifier derived from the location, symbol and content
+	ContentHash  string    `json:"content_hash,omitempty"`  // SHA-256 hash of the content
+	Content      string    `json:"content"`                 // The actual source code content
+	Context      string    `json:"context,omitempty"`       // The package clause and imports referenced by the content
+	Type         ChunkType `json:"type"`                    // The type of code (function, struct, method, etc.)
+	Name         string    `json:"name,omitempty"`          // The name of the function/struct/method
+	Path         string    `json:"path"`                    // The source file path
+	ImportPath   string    `json:"import_path,omitempty"`   // The import path of the package containing the file
+	Receiver     string    `json:"receiver,omitempty"`      // The receiver type for methods
+	ReceiverType string    `json:"receiver_type,omitempty"` // The type-checked receiver type of methods, qualified by import path
+	Signature    string    `json:"signature,omitempty"`     // The type-checked signature of functions and methods, qualified by import paths
+	TypeParams   []string  `json:"type_params,omitempty"`   // The type parameters of generic functions, types and receivers
+	Methods      []string  `json:"methods,omitempty"`       // The method signatures of interfaces
+	Embeds       []string  `json:"embeds,omitempty"`        // The embedded types and type set elements of interfaces
+	GroupDoc     string    `json:"group_doc,omitempty"`     // The doc comment shared by a grouped declaration
+	Imports      []string  `json:"imports,omitempty"`       // The import paths of the packages referenced by the content
+	References   []string  `json:"references,omitempty"`    // The type-checked package-level objects and methods referenced by the content
+	Calls        []string  `json:"calls,omitempty"`         // The functions and methods called by a function or method
+	CalledBy     []string  `json:"called_by,omitempty"`     // The identifiers of the chunks calling a function or method
+	Size         int       `json:"size"`                    // Number of tokens in the content
+	Tokenizer    string    `json:"tokenizer,omitempty"`     // The name of the tokenizer that counted the tokens
+	Lang         string    `json:"lang"`                    // The programming language of the chunk
+	Start        int       `json:"start"`                   // Starting line number of the content
+	End          int       `json:"end"`                     // Ending line number of the content
+	Part         int       `json:"part,omitempty"`          // The 1-based index of the part of a split chunk
+	Parts        int       `json:"parts,omitempty"`         // The number of parts the original chunk was split into
+	ParentID     string    `json:"parent_id,omitempty"`     // The identifier of the original chunk of a split chunk
+
+	prefix  string   // The file context at the start of Content, with ContextPrefix
+	callees []callee // The functions and methods called by the chunk, with WithCallers
+	object  string   // The type-checked full name of a function or method, with WithTypeCheck
+}
+
+func processFuncDecl(d *ast.FuncDecl, src []byte, fset *token.FileSet) *Chunk {
+	// Get the function name
+	name := d.Name.Name
+
+	// Get the function content including doc strings
+	start := d.Pos()
+	end := d.End()
+
+	if d.Doc != nil {
+		start = min(start, d.Doc.Pos())
+		end = max(end, d.Doc.End())
+	}
+
+	startPos := fset.Position(start)
+	endPos := fset.Position(end)
+	content := string(src[startPos.Offset:endPos.Offset])
+
+	if d.Recv != nil && len(d.Recv.List) > 0 {
+		// This is a method
+		receiverType := getReceiverType(d.Recv.List[0].Type)
+		return &Chunk{
+			Content:    content,
+			Type:       ChunkTypeMethod,
+			Name:       name,
+			Receiver:   receiverType,
+			TypeParams: getReceiverTypeParams(d.Recv.List[0].Type),
+			Calls:      getCalls(d.Body),
+			Lang:       LangGo,
+			Start:      startPos.Line,
+			End:        endPos.Line,
+		}
+	}
+
+	// This is a standalone function
+	return &Chunk{
+		Content:    content,
+		Type:       ChunkTypeFunction,
+		Name:       name,
+		TypeParams: getTypeParams(d.Type.TypeParams, src, fset),
+		Calls:      getCalls(d.Body),
+		Lang:       LangGo,
+		Start:      startPos.Line,
+		End:        endPos.Line,
+	}
+}
+
+func getReceiverType(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.StarExpr:
+		if x := getReceiverType(t.X); x != "" {
+			return "*" + x
+		}
+	case *ast.ParenExpr:
+		return getReceiverType(t.X)
+	case *ast.IndexExpr:
+		return getGenericReceiverType(t.X, []ast.Expr{t.Index})
+	case *ast.IndexListExpr:
+		return getGenericReceiverType(t.X, t.Indices)
+	}
+	return ""
+}
+
+// getGenericReceiverType returns the receiver type of a method on a generic type, e.g. "List[T]".
+func getGenericReceiverType(x ast.Expr, indices []ast.Expr) string {
+	name := getReceiverType(x)
+	if name == "" {
+		return ""
+	}
+	params := make([]string, 0, len(indices))
+	for _, index := range indices {
+		params = append(params, getReceiverType(index))
+	}
+	return name + "[" + strings.Join(params, ", ") + "]"
+}
+
+// getReceiverTypeParams returns the names of the type parameters of a generic receiver,
+// e.g. ["K", "V"] for "*Map[K, V]".
+func getReceiverTypeParams(expr ast.Expr) []string {
+	var indices []ast.Expr
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return getReceiverTypeParams(t.X)
+	case *ast.ParenExpr:
+		return getReceiverTypeParams(t.X)
+	case *ast.IndexExpr:
+		indices = []ast.Expr{t.Index}
+	case *ast.IndexListExpr:
+		indices = t.Indices
+	}
+
+	var params []string
+	for _, index := range indices {
+		if ident, ok := index.(*ast.Ident); ok {
+			params = append(params, ident.Name)
+		}
+	}
+	return params
+}
+
+// getTypeParams returns the type parameters of a generic function or type as they are
+// written in the source, one per name, e.g. ["K comparable", "V any"].
+func getTypeParams(fields *ast.FieldList, src []byte, fset *token.FileSet) []string {
+	if fields == nil {
+		return nil
+	}
+	var params []string
+	for _, field := range fields.List {
+		constraint := nodeText(field.Type, src, fset)
+		for _, name := range field.Names {
+			params = append(params, name.Name+" "+constraint)
+		}
+	}
+	return params
+}
+
+// isConstraintInterface reports whether the interface has type set elements such as "~int",
+// "int | float64", "int" or "[]byte", or embeds "comparable", so that it can only be used as a
+// type constraint. Embedded named types other than predeclared ones are taken for interfaces.
+func isConstraintInterface(t *ast.InterfaceType) bool {
+	for _, field := range t.Methods.List {
+		if len(field.Names) == 0 && isTypeElement(field.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTypeElement reports whether the embedded element of an interface restricts its type set to
+// other than interfaces with methods.
+func isTypeElement(expr ast.Expr) bool {
+	switch x := expr.(type) {
+	case *ast.ParenExpr:
+		return isTypeElement(x.X)
+	case *ast.UnaryExpr:
+		return x.Op == token.TILDE
+	case *ast.BinaryExpr:
+		return x.Op == token.OR
+	case *ast.Ident:
+		obj, ok := types.Universe.Lookup(x.Name).(*types.TypeName)
+		if !ok {
+			return false
+		}
+		iface, ok := obj.Type().Underlying().(*types.Interface)
+		return !ok || !iface.IsMethodSet()
+	case *ast.InterfaceType:
+		return isConstraintInterface(x)
+	case *ast.ArrayType, *ast.MapType, *ast.ChanType, *ast.FuncType, *ast.StructType, *ast.StarExpr:
+		return true
+	}
+	return false
+}
+
+func processTypeDecl(d *ast.GenDecl, src []byte, fset *token.FileSet) []*Chunk {
+	var chunks []*Chunk
+	for _, spec := range d.Specs {
+		typeSpec, ok := spec.(*ast.TypeSpec)
+		if !ok {
+			continue
+		}
+
+		var (
+			chunkType ChunkType
+			methods   []string
+			embeds    []string
+		)
+		if typeSpec.Assign.IsValid() {
+			chunkType = ChunkTypeAlias
+		} else {
+			switch t := typeSpec.Type.(type) {
+			case *ast.StructType:
+				chunkType = ChunkTypeStruct
+			case *ast.InterfaceType:
+				chunkType = ChunkTypeInterface
+				if isConstraintInterface(t) {
+					chunkType = ChunkTypeConstraint
+				}
+				methods, embeds = getInterfaceMembers(t, src, fset)
+			case *ast.FuncType:
+				chunkType = ChunkTypeFuncType
+			default:
+				chunkType = ChunkTypeType
+			}
+		}
+
+		name := typeSpec.Name.Name
+		start, end := typeSpecRange(d, typeSpec)
+		startPos := fset.Position(start)
+		endPos := fset.Position(end)
+		content := string(src[startPos.Offset:endPos.Offset])
+
+		var groupDoc string
+		if d.Lparen.IsValid() && d.Doc != nil {
+			groupDoc = nodeText(d.Doc, src, fset)
+		}
+
+		chunks = append(chunks, &Chunk{
+			Content:    content,
+			Type:       chunkType,
+			Name:       name,
+			TypeParams: getTypeParams(typeSpec.TypeParams, src, fset),
+			Methods:    methods,
+			Embeds:     embeds,
+			GroupDoc:   groupDoc,
+			Lang:       LangGo,
+			Start:      startPos.Line,
+			End:        endPos.Line,
+		})
+	}
+	return chunks
+}
+
+// typeSpecRange returns the source range of a single type spec including its comments.
+// A spec in a grouped "type ( ... )" declaration covers only itself and its own doc comment,
+// while a standalone declaration covers the whole declaration including its doc comment.
+func typeSpecRange(d *ast.GenDecl, spec *ast.TypeSpec) (start, end token.Pos) {
+	if d.Lparen.IsValid() {
+		start, end = spec.Pos(), spec.End()
+		if spec.Doc != nil {
+			start = spec.Doc.Pos()
+		}
+	} else {
+		start, end = d.Pos(), d.End()
+		if d.Doc != nil {
+			start = d.Doc.Pos()
+		}
+	}
+	if spec.Comment != nil {
+		end = max(end, spec.Comment.End())
+	}
+	return start, end
+}
+
+// getInterfaceMembers returns the method signatures and the embedded types of an interface
+// as they are written in the source, e.g. "Read(p []byte) (n int, err error)" and "io.Closer".
+func getInterfaceMembers(t *ast.InterfaceType, src []byte, fset *token.FileSet) (methods, embeds []string) {
+	for _, field := range t.Methods.List {
+		if len(field.Names) == 0 {
+			embeds = append(embeds, nodeText(field.Type, src, fset))
+			continue
+		}
+		for _, name := range field.Names {
+			methods = append(methods, name.Name+nodeText(field.Type, src, fset))
+		}
+	}
+	return methods, embeds
+}
+
+// nodeText returns the source text of the given node.
+func nodeText(node ast.Node, src []byte, fset *token.FileSet) string {
+	return string(src[fset.Position(node.Pos()).Offset:fset.Position(node.End()).Offset])
+}
+
+func processVarConstDecl(d *ast.GenDecl, src []byte, fset *token.FileSet) *Chunk {
+	start := d.Pos()
+	end := d.End()
+
+	if d.Doc != nil {
+		start = min(start, d.Doc.Pos())
+		end = max(end, d.Doc.End())
+	}
+	if len(d.Specs) > 0 {
+		if v, ok := d.Specs[len(d.Specs)-1].(*ast.ValueSpec); ok {
+			if v.Comment != nil && len(v.Comment.List) > 0 {
+				end = max(end, v.Comment.List[len(v.Comment.List)-1].End())
+			}
+		}
+	}
+	if d.Lparen.IsValid() {
+		start = min(start, d.Lparen)
+	}
+	if d.Rparen.IsValid() {
+		end = max(end, d.Rparen)
+	}
+
+	startPos := fset.Position(start)
+	endPos := fset.Position(end)
+	content := string(src[startPos.Offset:endPos.Offset])
+
+	chunkType := ChunkTypeVar
+	if d.Tok == token.CONST {
+		chunkType = ChunkTypeConst
+	}
+
+	return &Chunk{
+		Content: content,
+		Type:    chunkType,
+		Lang:    LangGo,
+		Start:   startPos.Line,
+		End:     endPos.Line,
+	}
+}
+
+func extractChunks(file *ast.File, src []byte, fset *token.FileSet) []*Chunk {
+	var chunks []*Chunk
+	for chunk := range declChunks(file, src, fset) {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// declChunks returns an iterator over the chunks of the declarations of file in source order,
+// together with the syntax nodes they were extracted from: a function declaration, a type spec,
+// or a var or const declaration. The chunks of a declaration are only extracted when the
+// iteration reaches it.
+func declChunks(file *ast.File, src []byte, fset *token.FileSet) iter.Seq2[*Chunk, ast.Node] {
+	return func(yield func(*Chunk, ast.Node) bool) {
+		for _, decl := range file.Decls {
+			var (
+				chunks []*Chunk
+				nodes  []ast.Node
+			)
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				chunks, nodes = []*Chunk{processFuncDecl(d, src, fset)}, []ast.Node{d}
+			case *ast.GenDecl:
+				switch d.Tok {
+				case token.TYPE:
+					chunks = processTypeDecl(d, src, fset)
+					for _, spec := range d.Specs {
+						if _, ok := spec.(*ast.TypeSpec); ok {
+							nodes = append(nodes, spec)
+						}
+					}
+				case token.VAR, token.CONST:
+					chunks, nodes = []*Chunk{processVarConstDecl(d, src, fset)}, []ast.Node{d}
+				}
+			}
+			for i, chunk := range chunks {
+				for _, spec := range referencedImports(file, nodes[i]) {
+					chunk.Imports = append(chunk.Imports, importPath(spec))
+				}
+				if !yield(chunk, nodes[i]) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// processFile reads and parses the Go source file at path.
+func processFile(path string) (*parsedFile, error) {
+	src, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %v", err)
+	}
+	return processSource(path, src)
+}
+
+// processSource parses the Go source code src of the named file.
+func processSource(filename string, src []byte) (*parsedFile, error) {
+	return parseSource(token.NewFileSet(), filename, src)
+}
+
+// parseSource parses the Go source code src of the named file into fset, which is shared by
+// the files of a package that are type-checked together.
+func parseSource(fset *token.FileSet, filename string, src []byte) (*parsedFile, error) {
+	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing file: %v", err)
+	}
+
+	return &parsedFile{file: file, src: src, fset: fset}, nil
+}
@@ -0,0 +1,217 @@
+package gosplit
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"iter"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ContextMode selects whether and how the file context of a chunk is included in its output.
+// The file context consists of the package clause of the file and the import declarations
+// of the packages referenced by the chunk, e.g.:
+//
+//	package server
+//
+//	import (
+//		"net/http"
+//		sqlx "github.com/jmoiron/sqlx"
+//	)
+type ContextMode string
+
+const (
+	// ContextNone omits the file context.
+	ContextNone ContextMode = "none"
+	// ContextField records the file context in the Context field of chunks.
+	// The size of a chunk counts the tokens of its content only.
+	ContextField ContextMode = "field"
+	// ContextPrefix prepends the file context to the content of chunks, separated by a blank line.
+	// The size of a chunk counts the tokens of the file context, and chunks are split so that
+	// every part fits into the chunk size together with the file context.
+	ContextPrefix ContextMode = "prefix"
+)
+
+// ContextModes returns the available context modes.
+func ContextModes() []ContextMode {
+	return []ContextMode{ContextNone, ContextField, ContextPrefix}
+}
+
+// parsedFile is a parsed Go source file.
+type parsedFile struct {
+	file *ast.File
+	src  []byte
+	fset *token.FileSet
+	info *types.Info // The type information of the package of the file, with WithTypeCheck
+}
+
+// chunks returns an iterator over the chunks of the file in source order, together with the
+// syntax nodes they were extracted from.
+func (f *parsedFile) chunks() iter.Seq2[*Chunk, ast.Node] {
+	return declChunks(f.file, f.src, f.fset)
+}
+
+// context returns the file context of the chunk extracted from node.
+func (f *parsedFile) context(node ast.Node) string {
+	var b strings.Builder
+	b.WriteString("package " + f.file.Name.Name)
+
+	specs := referencedImports(f.file, node)
+	switch len(specs) {
+	case 0:
+	case 1:
+		b.WriteString("\n\nimport " + nodeText(specs[0], f.src, f.fset))
+	default:
+		b.WriteString("\n\nimport (")
+		for _, spec := range specs {
+			b.WriteString("\n\t" + nodeText(spec, f.src, f.fset))
+		}
+		b.WriteString("\n)")
+	}
+	return b.String()
+}
+
+// referencedImports returns the import specs of file whose package names are referenced by
+// selector expressions within node, in the order they are imported.
+// Without type information, a local variable shadowing a package name is taken for the package.
+func referencedImports(file *ast.File, node ast.Node) []*ast.ImportSpec {
+	names := make(map[string]bool)
+	ast.Inspect(node, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if ident, ok := sel.X.(*ast.Ident); ok {
+				names[ident.Name] = true
+			}
+		}
+		return true
+	})
+
+	var specs []*ast.ImportSpec
+	for _, spec := range file.Imports {
+		if names[importName(spec)] {
+			specs = append(specs, spec)
+		}
+	}
+	return specs
+}
+
+// importName returns the name by which the package imported by spec is referenced.
+// It returns "_" and "." for blank and dot imports, which are never referenced by selectors.
+func importName(spec *ast.ImportSpec) string {
+	if spec.Name != nil {
+		return spec.Name.Name
+	}
+	return assumedPackageName(importPath(spec))
+}
+
+// importPath returns the import path of spec.
+func importPath(spec *ast.ImportSpec) string {
+	path, err := strconv.Unquote(spec.Path.Value)
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// assumedPackageName returns the package name assumed for an import path in the same way as
+// goimports: the last path element without a "go-" prefix, a major version element such as
+// "v2", or any suffix starting at a character that cannot appear in an identifier, such as the
+// ".v3" of "gopkg.in/yaml.v3".
+func assumedPackageName(path string) string {
+	elems := strings.Split(path, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && isMajorVersion(name) {
+		name = elems[len(elems)-2]
+	}
+	name = strings.TrimPrefix(name, "go-")
+	if i := strings.IndexFunc(name, func(r rune) bool {
+		return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// isMajorVersion reports whether a path element is a major version suffix such as "v2".
+func isMajorVersion(elem string) bool {
+	if len(elem) < 2 || elem[0] != 'v' {
+		return false
+	}
+	n, err := strconv.Atoi(elem[1:])
+	return err == nil && n >= 2 && elem[1] != '0'
+}
+
+// prefixContext prepends the file context to the content of chunk, and updates its size and ID.
+// SplitChunk splits the content without the file context, and prepends it to every part.
+func (s *Splitter) prefixContext(chunk *Chunk, context string) error {
+	prefix := context + "\n\n"
+	size, err := s.tokenizer.Count(prefix + chunk.Content)
+	if err != nil {
+		return fmt.Errorf("error counting tokens: %v", err)
+	}
+	chunk.Content = prefix + chunk.Content
+	chunk.Size = size
+	chunk.prefix = prefix
+	setChunkID(chunk)
+	return nil
+}
+
+// splitPrefixedChunk splits a chunk whose content starts with its file context, so that every
+// part starts with the file context and fits into the chunk size together with it. Parts that
+// take more tokens together with the file context than on their own are split again into
+// smaller parts.
+func (s *Splitter) splitPrefixedChunk(chunk *Chunk) ([]*Chunk, error) {
+	prefixTokens, err := s.tokenizer.Count(chunk.prefix)
+	if err != nil {
+		return nil, fmt.Errorf("error counting tokens: %v", err)
+	}
+	if prefixTokens >= s.chunkSize {
+		return nil, fmt.Errorf("file context of %d tokens does not fit into chunk size %d", prefixTokens, s.chunkSize)
+	}
+
+	code := *chunk
+	code.Content = strings.TrimPrefix(chunk.Content, chunk.prefix)
+	if code.Size, err = s.tokenizer.Count(code.Content); err != nil {
+		return nil, fmt.Errorf("error counting tokens: %v", err)
+	}
+	code.prefix = ""
+
+	// The overlap is scaled down to the tokens left for the code, so that every part still carries
+	// over the same share of its code as without the file context and makes progress.
+	opts := s.split
+	opts.Overlap = s.split.Overlap * (s.chunkSize - prefixTokens) / s.chunkSize
+	if opts.Overlap > 0 {
+		sepTokens, err := s.tokenizer.Count("\n")
+		if err != nil {
+			return nil, fmt.Errorf("error counting tokens: %v", err)
+		}
+		if opts.Overlap+sepTokens >= s.chunkSize-prefixTokens {
+			return nil, fmt.Errorf("chunk overlap of %d tokens does not fit into chunk size %d with the file context of %d tokens", opts.Overlap, s.chunkSize, prefixTokens)
+		}
+	}
+	for budget := s.chunkSize - prefixTokens; budget > 0; {
+		parts, err := splitChunk(&code, s.tokenizer, budget, opts)
+		if err != nil {
+			return nil, fmt.Errorf("error splitting chunk: %v", err)
+		}
+		if len(parts) == 1 && parts[0] == &code {
+			return []*Chunk{chunk}, nil
+		}
+		excess := 0
+		for _, part := range parts {
+			part.Content = chunk.prefix + part.Content
+			if part.Size, err = s.tokenizer.Count(part.Content); err != nil {
+				return nil, fmt.Errorf("error counting tokens: %v", err)
+			}
+			excess = max(excess, part.Size-s.chunkSize)
+			setChunkID(part)
+		}
+		if excess == 0 {
+			return parts, nil
+		}
+		budget -= excess
+	}
+	return nil, fmt.Errorf("chunk cannot be split into parts of at most %d tokens with its file context", s.chunkSize)
+}
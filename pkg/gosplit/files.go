@@ -0,0 +1,159 @@
+package gosplit
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// recursiveSuffix marks a pattern that matches a directory and all of its subdirectories,
+// in the same way as the "./..." pattern of the go command.
+const recursiveSuffix = "..."
+
+// CollectFiles expands the given paths and patterns into a sorted list of Go source files.
+// Each argument can be a file, a directory (its .go files are used), a directory followed by
+// "/..." (its .go files are used recursively), or a glob pattern matching any of the former.
+func CollectFiles(args []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var files []string
+	add := func(path string) {
+		path = filepath.Clean(path)
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+
+	for _, arg := range args {
+		matches, err := expandArg(arg)
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			add(match)
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// expandArg expands a single command-line argument into the Go source files it refers to.
+func expandArg(arg string) ([]string, error) {
+	if arg == recursiveSuffix || strings.HasSuffix(arg, "/"+recursiveSuffix) {
+		dir := strings.TrimSuffix(strings.TrimSuffix(arg, recursiveSuffix), "/")
+		if dir == "" {
+			dir = "."
+		}
+		return walkDir(dir)
+	}
+
+	paths := []string{arg}
+	if strings.ContainsAny(arg, "*?[") {
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", arg, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("pattern %q matched no files", arg)
+		}
+		paths = matches
+	}
+
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", path, err)
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		dirFiles, err := listDir(path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, dirFiles...)
+	}
+	return files, nil
+}
+
+// listDir returns the Go source files directly contained in dir.
+func listDir(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory: %v", err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && IsGoFile(entry.Name()) {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
+// walkDir returns the Go source files in dir and all of its subdirectories.
+// Like the go command, it skips vendor and testdata directories and directories
+// whose names begin with "." or "_".
+func walkDir(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && SkipDir(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if IsGoFile(d.Name()) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error walking directory: %v", err)
+	}
+	return files, nil
+}
+
+// SkipDir reports whether the subdirectories with the given name are skipped by SplitDir
+// and the "/..." pattern of CollectFiles.
+func SkipDir(name string) bool {
+	return name == "vendor" || name == "testdata" ||
+		strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")
+}
+
+// IsGoFile reports whether the file with the given name is a Go source file to be split.
+func IsGoFile(name string) bool {
+	return strings.HasSuffix(name, ".go") && !strings.HasPrefix(name, ".") && !strings.HasPrefix(name, "_")
+}
+
+// chunkPath returns the path recorded in the chunks of the given file.
+// If root is empty, the path is returned as it was found; otherwise it is made relative to root.
+func chunkPath(root, path string) (string, error) {
+	if root == "" {
+		return path, nil
+	}
+
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", fmt.Errorf("error resolving root: %v", err)
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("error resolving path: %v", err)
+	}
+	rel, err := filepath.Rel(absRoot, absPath)
+	if err != nil {
+		return "", fmt.Errorf("error making path relative to root: %v", err)
+	}
+	return filepath.ToSlash(rel), nil
+}
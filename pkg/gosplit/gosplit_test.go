@@ -0,0 +1,2062 @@
+package gosplit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type GoSplitTestSuite struct {
+	suite.Suite
+	tmpDir string
+	tok    Tokenizer
+}
+
+func (s *GoSplitTestSuite) SetupTest() {
+	s.tmpDir = s.T().TempDir()
+
+	// A small fixture with every byte and a few merges, so that sizes do not depend on the real ranks.
+	tok, err := NewTokenizer(DefaultTokenizer, filepath.Join("testdata", "ranks.tiktoken"))
+	require.NoError(s.T(), err)
+	s.tok = tok
+}
+
+func (s *GoSplitTestSuite) copyTestFile(name string) string {
+	src := filepath.Join("testdata", name)
+	dst := filepath.Join(s.tmpDir, name)
+
+	content, err := os.ReadFile(filepath.Clean(src))
+	require.NoError(s.T(), err, "Failed to read test file")
+
+	err = os.WriteFile(dst, content, 0o600)
+	require.NoError(s.T(), err, "Failed to write test file")
+
+	return dst
+}
+
+func (s *GoSplitTestSuite) TestExtractChunks() {
+	testFile := s.copyTestFile("basic.go")
+	content, err := os.ReadFile(filepath.Clean(testFile))
+	require.NoError(s.T(), err, "Failed to read test file")
+
+	// Parse the test file
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, testFile, content, parser.ParseComments)
+	require.NoError(s.T(), err, "Failed to parse test file")
+
+	assert.Equal(s.T(), []*Chunk{
+		{
+			Lang: "go",
+			Type: ChunkTypeStruct,
+			Name: "User",
+			Content: `type User struct {
+	Name string
+	Age  int
+}`,
+			Start: 5,
+			End:   8,
+		},
+		{
+			Lang: "go",
+			Type: ChunkTypeFunction,
+			Name: "Hello",
+			Content: `func Hello() {
+	fmt.Println("Hello, world!")
+}`,
+			Imports: []string{"fmt"},
+			Calls:   []string{"fmt.Println"},
+			Start:   10,
+			End:     12,
+		},
+	}, extractChunks(file, content, fset))
+}
+
+func (s *GoSplitTestSuite) TestExtractChunksWithMethod() {
+	testFile := s.copyTestFile("with_method.go")
+	content, err := os.ReadFile(filepath.Clean(testFile))
+	require.NoError(s.T(), err, "Failed to read test file")
+
+	// Parse the test file
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, testFile, content, parser.ParseComments)
+	require.NoError(s.T(), err, "Failed to parse test file")
+
+	assert.Equal(s.T(), []*Chunk{
+		{
+			Lang: "go",
+			Type: ChunkTypeStruct,
+			Name: "User",
+			Content: `type User struct {
+	Name string
+	Age  int
+}`,
+			Start: 5,
+			End:   8,
+		},
+		{
+			Lang:     "go",
+			Type:     ChunkTypeMethod,
+			Name:     "Method",
+			Receiver: "*User",
+			Content: `func (u *User) Method() {
+	fmt.Printf("User: %s, Age: %d\n", u.Name, u.Age)
+}`,
+			Imports: []string{"fmt"},
+			Calls:   []string{"fmt.Printf"},
+			Start:   10,
+			End:     12,
+		},
+	}, extractChunks(file, content, fset))
+}
+
+func (s *GoSplitTestSuite) TestExtractChunksWithDocs() {
+	testFile := s.copyTestFile("with_docs.go")
+	content, err := os.ReadFile(filepath.Clean(testFile))
+	require.NoError(s.T(), err, "Failed to read test file")
+
+	// Parse the test file
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, testFile, content, parser.ParseComments)
+	require.NoError(s.T(), err, "Failed to parse test file")
+
+	assert.Equal(s.T(), []*Chunk{
+		{
+			Lang: "go",
+			Type: ChunkTypeStruct,
+			Name: "User",
+			Content: `// User represents a user in the system.
+// It contains basic user information.
+type User struct {
+	// Name is the user's full name
+	Name string
+	// Age represents the user's age in years
+	Age int
+}`,
+			Start: 4,
+			End:   11,
+		},
+		{
+			Lang: "go",
+			Type: ChunkTypeFunction,
+			Name: "NewUser",
+			Content: `// NewUser creates a new User instance.
+// It validates the input parameters before creating the user.
+func NewUser(name string, age int) *User {
+	return &User{
+		Name: name,
+		Age:  age,
+	}
+}`,
+			Start: 13,
+			End:   20,
+		},
+		{
+			Lang: "go",
+			Type: ChunkTypeStruct,
+			Name: "UserService",
+			Content: `// UserService handles user-related operations.
+type UserService struct {
+	// users stores all registered users
+	users []*User
+}`,
+			Start: 22,
+			End:   26,
+		},
+		{
+			Lang:     "go",
+			Type:     ChunkTypeMethod,
+			Name:     "AddUser",
+			Receiver: "*UserService",
+			Content: `// AddUser adds a new user to the service.
+// It returns an error if the user is invalid.
+func (s *UserService) AddUser(u *User) error {
+	// TODO: implement validation
+	s.users = append(s.users, u)
+	return nil
+}`,
+			Start: 28,
+			End:   34,
+		},
+	}, extractChunks(file, content, fset))
+}
+
+func (s *GoSplitTestSuite) TestExtractChunksWithVars() {
+	testFile := s.copyTestFile("with_vars.go")
+	content, err := os.ReadFile(filepath.Clean(testFile))
+	require.NoError(s.T(), err, "Failed to read test file")
+
+	// Parse the test file
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, testFile, content, parser.ParseComments)
+	require.NoError(s.T(), err, "Failed to parse test file")
+
+	assert.Equal(s.T(), []*Chunk{
+		{
+			Lang: "go",
+			Type: ChunkTypeConst,
+			Content: `// Package-level comment for MaxRetries
+// This is a multi-line comment
+// explaining the purpose of MaxRetries
+const MaxRetries = 3`,
+			Start: 3,
+			End:   6,
+		},
+		{
+			Lang:    "go",
+			Type:    ChunkTypeConst,
+			Content: `const DefaultTimeout = 30 // Inline comment for DefaultTimeout`,
+			Start:   8,
+			End:     8,
+		},
+		{
+			Lang: "go",
+			Type: ChunkTypeConst,
+			Content: `// Group of error messages
+// Each constant represents a specific error case
+const (
+	// ErrNotFound is returned when a resource is not found
+	ErrNotFound = "not found"
+
+	ErrInvalidData = "invalid data" // Inline comment for ErrInvalidData
+
+	// ErrTimeout represents a timeout error
+	// It includes the timeout duration in the message
+	ErrTimeout = "operation timed out"
+)`,
+			Start: 10,
+			End:   21,
+		},
+		{
+			Lang: "go",
+			Type: ChunkTypeConst,
+			Content: `// Numeric constants with different types
+const (
+	Pi         = 3.14159
+	MaxInt32   = 1<<31 - 1
+	MinInt32   = -1 << 31
+	MaxUint32  = 1<<32 - 1
+	MaxFloat32 = 3.402823e+38
+	MinFloat32 = 1.401298e-45
+)`,
+			Start: 23,
+			End:   31,
+		},
+		{
+			Lang: "go",
+			Type: ChunkTypeConst,
+			Content: `// Boolean flags
+const (
+	IsProduction = false
+	EnableCache  = true
+	UseSSL       = true
+)`,
+			Start: 33,
+			End:   38,
+		},
+		{
+			Lang: "go",
+			Type: ChunkTypeVar,
+			Content: `// Config holds application configuration
+// It contains basic server settings
+var Config = struct {
+	Host string // Server hostname
+	Port int    // Server port number
+}{
+	Host: "localhost", // Default host
+	Port: 8080,        // Default port
+}`,
+			Start: 40,
+			End:   48,
+		},
+		{
+			Lang:    "go",
+			Type:    ChunkTypeVar,
+			Content: `var Debug = false // Global debug flag`,
+			Start:   50,
+			End:     50,
+		},
+		{
+			Lang: "go",
+			Type: ChunkTypeVar,
+			Content: `// Version information
+// Contains build metadata
+var (
+	// Version represents the current release version
+	Version = "1.0.0"
+
+	BuildTime = "2024-03-20" // Build timestamp
+
+	// CommitHash stores the git commit hash
+	// Used for version tracking
+	CommitHash = "abc123"
+)`,
+			Start: 52,
+			End:   63,
+		},
+		{
+			Lang: "go",
+			Type: ChunkTypeVar,
+			Content: `// Database configuration
+var DBConfig = struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	Database string
+	SSL      bool
+}{
+	Host:     "db.example.com",
+	Port:     5432,
+	User:     "admin",
+	Password: "secret",
+	Database: "my_db",
+	SSL:      true,
+}`,
+			Start: 65,
+			End:   80,
+		},
+		{
+			Lang: "go",
+			Type: ChunkTypeVar,
+			Content: `// Feature flags with different comment styles
+var (
+	// EnableNewUI controls the new user interface
+	// When true, the new UI is shown
+	// When false, the legacy UI is used
+	EnableNewUI = true
+
+	EnableAnalytics = false // Simple inline comment
+
+	/* EnableLogging is a multi-line
+	   comment using block style
+	   for better readability */
+	EnableLogging = true
+
+	// EnableMetrics controls metrics collection
+	EnableMetrics = true // Additional inline detail
+)`,
+			Start: 82,
+			End:   98,
+		},
+		{
+			Lang: "go",
+			Type: ChunkTypeVar,
+			Content: `// Cache settings with mixed comment styles
+var CacheSettings = struct {
+	// MaxSize defines the maximum cache size in bytes
+	MaxSize int64
+	TTL     int // Time-to-live in seconds
+	/* Compression enables data compression
+	   when storing cache entries */
+	Compression bool
+	Algorithm   string // Cache replacement algorithm
+}{
+	MaxSize:     1024 * 1024 * 100, // 100MB
+	TTL:         3600,              // 1 hour
+	Compression: true,              // Enable compression
+	Algorithm:   "lru",             // Least Recently Used
+}`,
+			Start: 100,
+			End:   114,
+		},
+		{
+			Lang: "go",
+			Type: ChunkTypeVar,
+			Content: `// unexported variables
+var (
+	internalCounter = 0
+	debugLevel      = 2
+	secretKey       = "internal-secret-key"
+)`,
+			Start: 116,
+			End:   121,
+		},
+		{
+			Lang: "go",
+			Type: ChunkTypeConst,
+			Content: `// API endpoints
+const (
+	APIVersion = "v1"
+	BaseURL    = "https://api.example.com"
+)`,
+			Start: 123,
+			End:   127,
+		},
+		{
+			Lang: "go",
+			Type: ChunkTypeConst,
+			Content: `// HTTP methods with different comment positions
+const (
+	// MethodGet represents HTTP GET method
+	MethodGet = "GET"
+
+	MethodPost = "POST" // HTTP POST method
+
+	/* MethodPut represents HTTP PUT method
+	   Used for updating resources */
+	MethodPut = "PUT"
+
+	// MethodDelete represents HTTP DELETE method
+	// Used for removing resources
+	MethodDelete = "DELETE"
+)`,
+			Start: 129,
+			End:   143,
+		},
+		{
+			Lang: "go",
+			Type: ChunkTypeConst,
+			Content: `// Status codes with various comment styles
+const (
+	StatusOK = 200 // Success
+
+	// StatusCreated indicates successful resource creation
+	StatusCreated = 201
+
+	/* StatusNotFound indicates that the requested
+	   resource was not found on the server */
+	StatusNotFound = 404
+
+	// StatusInternal represents server errors
+	// Should be logged for investigation
+	StatusInternal = 500
+)`,
+			Start: 145,
+			End:   159,
+		},
+	}, extractChunks(file, content, fset))
+}
+
+func (s *GoSplitTestSuite) TestExtractChunksWithInterfaces() {
+	testFile := s.copyTestFile("with_interfaces.go")
+	content, err := os.ReadFile(filepath.Clean(testFile))
+	require.NoError(s.T(), err, "Failed to read test file")
+
+	// Parse the test file
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, testFile, content, parser.ParseComments)
+	require.NoError(s.T(), err, "Failed to parse test file")
+
+	assert.Equal(s.T(), []*Chunk{
+		{
+			Lang: "go",
+			Type: ChunkTypeInterface,
+			Name: "Store",
+			Methods: []string{
+				"Get(key string) ([]byte, error)",
+				"Put(key string, value []byte) error",
+			},
+			Content: `// Store persists key-value pairs.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the value stored under key.
+	Get(key string) ([]byte, error)
+	// Put stores value under key.
+	Put(key string, value []byte) error
+}`,
+			Start: 5,
+			End:   12,
+		},
+		{
+			Lang:    "go",
+			Type:    ChunkTypeInterface,
+			Name:    "ReadStore",
+			Methods: []string{"Len() int"},
+			Embeds:  []string{"Store", "io.ReadCloser"},
+			Content: `// ReadStore is a Store that can also be read as a stream.
+type ReadStore interface {
+	Store
+	io.ReadCloser
+
+	Len() int
+}`,
+			Imports: []string{"io"},
+			Start:   14,
+			End:     20,
+		},
+		{
+			Lang:    "go",
+			Type:    ChunkTypeInterface,
+			Name:    "Empty",
+			Content: `type Empty interface{}`,
+			Start:   22,
+			End:     22,
+		},
+	}, extractChunks(file, content, fset))
+}
+
+func (s *GoSplitTestSuite) TestExtractChunksWithTypes() {
+	testFile := s.copyTestFile("with_types.go")
+	content, err := os.ReadFile(filepath.Clean(testFile))
+	require.NoError(s.T(), err, "Failed to read test file")
+
+	// Parse the test file
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, testFile, content, parser.ParseComments)
+	require.NoError(s.T(), err, "Failed to parse test file")
+
+	assert.Equal(s.T(), []*Chunk{
+		{
+			Lang: "go",
+			Type: ChunkTypeType,
+			Name: "Status",
+			Content: `// Status is the state of a job.
+type Status int`,
+			Start: 5,
+			End:   6,
+		},
+		{
+			Lang: "go",
+			Type: ChunkTypeFuncType,
+			Name: "HandlerFunc",
+			Content: `// HandlerFunc handles a request.
+type HandlerFunc func(w http.ResponseWriter, r *http.Request) error`,
+			Imports: []string{"net/http"},
+			Start:   8,
+			End:     9,
+		},
+		{
+			Lang:    "go",
+			Type:    ChunkTypeType,
+			Name:    "Labels",
+			Content: `type Labels map[string]string`,
+			Start:   11,
+			End:     11,
+		},
+		{
+			Lang: "go",
+			Type: ChunkTypeAlias,
+			Name: "Handler",
+			Content: `// Handler is an alias kept for compatibility.
+type Handler = http.Handler`,
+			Imports: []string{"net/http"},
+			Start:   13,
+			End:     14,
+		},
+		{
+			Lang:     "go",
+			Type:     ChunkTypeType,
+			Name:     "JobID",
+			GroupDoc: "// Identifiers used by the scheduler.",
+			Content:  `JobID string // Unique within a scheduler`,
+			Start:    18,
+			End:      18,
+		},
+		{
+			Lang:     "go",
+			Type:     ChunkTypeStruct,
+			Name:     "Worker",
+			GroupDoc: "// Identifiers used by the scheduler.",
+			Content: `// Worker runs jobs.
+	Worker struct {
+		ID string
+	}`,
+			Start: 20,
+			End:   23,
+		},
+	}, extractChunks(file, content, fset))
+}
+
+func (s *GoSplitTestSuite) TestExtractChunksWithGenerics() {
+	testFile := s.copyTestFile("with_generics.go")
+	content, err := os.ReadFile(filepath.Clean(testFile))
+	require.NoError(s.T(), err, "Failed to read test file")
+
+	// Parse the test file
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, testFile, content, parser.ParseComments)
+	require.NoError(s.T(), err, "Failed to parse test file")
+
+	assert.Equal(s.T(), []*Chunk{
+		{
+			Lang:   "go",
+			Type:   ChunkTypeConstraint,
+			Name:   "Number",
+			Embeds: []string{"~int | ~int64 | ~float64"},
+			Content: `// Number is a constraint satisfied by numeric types.
+type Number interface {
+	~int | ~int64 | ~float64
+}`,
+			Start: 5,
+			End:   8,
+		},
+		{
+			Lang:       "go",
+			Type:       ChunkTypeStruct,
+			Name:       "List",
+			TypeParams: []string{"T any"},
+			Content: `// List is a generic linked list.
+type List[T any] struct {
+	head *node[T]
+}`,
+			Start: 10,
+			End:   13,
+		},
+		{
+			Lang:       "go",
+			Type:       ChunkTypeMethod,
+			Name:       "Push",
+			Receiver:   "*List[T]",
+			TypeParams: []string{"T"},
+			Content: `// Push adds v to the front of the list.
+func (l *List[T]) Push(v T) {
+	l.head = &node[T]{value: v, next: l.head}
+}`,
+			Start: 15,
+			End:   18,
+		},
+		{
+			Lang:       "go",
+			Type:       ChunkTypeStruct,
+			Name:       "Pair",
+			TypeParams: []string{"K comparable", "V any"},
+			Content: `type Pair[K comparable, V any] struct {
+	Key   K
+	Value V
+}`,
+			Start: 20,
+			End:   23,
+		},
+		{
+			Lang:       "go",
+			Type:       ChunkTypeMethod,
+			Name:       "String",
+			Receiver:   "Pair[K, V]",
+			TypeParams: []string{"K", "V"},
+			Content: `func (p Pair[K, V]) String() string {
+	return fmt.Sprint(p.Key, p.Value)
+}`,
+			Imports: []string{"fmt"},
+			Calls:   []string{"fmt.Sprint"},
+			Start:   25,
+			End:     27,
+		},
+		{
+			Lang:       "go",
+			Type:       ChunkTypeFunction,
+			Name:       "Sum",
+			TypeParams: []string{"S ~[]E", "E Number"},
+			Content: `// Sum returns the sum of the values.
+func Sum[S ~[]E, E Number](values S) E {
+	var sum E
+	for _, v := range values {
+		sum += v
+	}
+	return sum
+}`,
+			Start: 29,
+			End:   36,
+		},
+	}, extractChunks(file, content, fset))
+}
+
+func (s *GoSplitTestSuite) TestIsConstraintInterface() {
+	tt := []struct {
+		iface      string
+		constraint bool
+	}{
+		{iface: "interface{ ~int }", constraint: true},
+		{iface: "interface{ int | string }", constraint: true},
+		{iface: "interface{ int }", constraint: true},
+		{iface: "interface{ []byte }", constraint: true},
+		{iface: "interface{ *T }", constraint: true},
+		{iface: "interface{ comparable }", constraint: true},
+		{iface: "interface{ comparable; String() string }", constraint: true},
+		{iface: "interface{ interface{ ~string } }", constraint: true},
+		{iface: "interface{}", constraint: false},
+		{iface: "interface{ String() string }", constraint: false},
+		{iface: "interface{ error }", constraint: false},
+		{iface: "interface{ any }", constraint: false},
+		{iface: "interface{ io.Reader; Stringer }", constraint: false},
+	}
+	for _, tt := range tt {
+		s.T().Run(tt.iface, func(t *testing.T) {
+			expr, err := parser.ParseExpr(tt.iface)
+			require.NoError(t, err)
+			assert.Equal(t, tt.constraint, isConstraintInterface(expr.(*ast.InterfaceType)))
+		})
+	}
+}
+
+func (s *GoSplitTestSuite) TestProcessFile() {
+	// Test with non-existent file
+	_, err := processFile("non_existent.go")
+	assert.Error(s.T(), err, "Expected error for non-existent file")
+
+	// Test with invalid Go file
+	invalidFile := filepath.Join(s.tmpDir, "invalid.go")
+	err = os.WriteFile(invalidFile, []byte("invalid go code"), 0o600)
+	require.NoError(s.T(), err, "Failed to write invalid test file")
+
+	_, err = processFile(invalidFile)
+	assert.Error(s.T(), err, "Expected error for invalid Go file")
+}
+
+func (s *GoSplitTestSuite) writeFile(name, content string) string {
+	path := filepath.Join(s.tmpDir, filepath.FromSlash(name))
+	err := os.MkdirAll(filepath.Dir(path), 0o700)
+	require.NoError(s.T(), err, "Failed to create directory")
+	err = os.WriteFile(path, []byte(content), 0o600)
+	require.NoError(s.T(), err, "Failed to write test file")
+	return path
+}
+
+func (s *GoSplitTestSuite) TestCollectFiles() {
+	for _, name := range []string{
+		"a.go",
+		"b.go",
+		"README.md",
+		"sub/c.go",
+		"sub/deep/d.go",
+		"vendor/v.go",
+		"testdata/t.go",
+		".hidden/h.go",
+		"_ignored/i.go",
+	} {
+		s.writeFile(name, "package p\n")
+	}
+	path := func(name string) string {
+		return filepath.Join(s.tmpDir, filepath.FromSlash(name))
+	}
+
+	tt := []struct {
+		name     string
+		args     []string
+		expected []string
+	}{
+		{
+			name:     "file",
+			args:     []string{path("a.go")},
+			expected: []string{path("a.go")},
+		},
+		{
+			name:     "directory",
+			args:     []string{s.tmpDir},
+			expected: []string{path("a.go"), path("b.go")},
+		},
+		{
+			name: "recursive",
+			args: []string{s.tmpDir + "/..."},
+			expected: []string{
+				path("a.go"),
+				path("b.go"),
+				path("sub/c.go"),
+				path("sub/deep/d.go"),
+			},
+		},
+		{
+			name:     "glob",
+			args:     []string{filepath.Join(s.tmpDir, "sub", "*")},
+			expected: []string{path("sub/c.go"), path("sub/deep/d.go")},
+		},
+		{
+			name:     "multiple paths are deduplicated",
+			args:     []string{path("sub/deep"), path("a.go"), path("sub/deep/d.go")},
+			expected: []string{path("a.go"), path("sub/deep/d.go")},
+		},
+		{
+			name:     "explicit testdata directory",
+			args:     []string{path("testdata")},
+			expected: []string{path("testdata/t.go")},
+		},
+	}
+	for _, tt := range tt {
+		s.T().Run(tt.name, func(t *testing.T) {
+			files, err := CollectFiles(tt.args)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, files)
+		})
+	}
+
+	_, err := CollectFiles([]string{path("missing.go")})
+	assert.Error(s.T(), err, "Expected error for non-existent file")
+	_, err = CollectFiles([]string{path("*.txt")})
+	assert.Error(s.T(), err, "Expected error for pattern without matches")
+}
+
+func (s *GoSplitTestSuite) TestChunkPath() {
+	file := filepath.Join(s.tmpDir, "sub", "c.go")
+
+	path, err := chunkPath("", file)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), file, path)
+
+	path, err = chunkPath(s.tmpDir, file)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), "sub/c.go", path)
+}
+
+func (s *GoSplitTestSuite) TestLoadPackageFiles() {
+	s.writeFile("go.mod", "module example.com/m\n\ngo 1.22\n")
+	s.writeFile("a.go", "package m\n")
+	s.writeFile("a_linux.go", "package m\n")
+	s.writeFile("a_windows.go", "package m\n")
+	s.writeFile("a_test.go", "package m\n")
+	s.writeFile("tagged.go", "//go:build special\n\npackage m\n")
+	s.writeFile("sub/b.go", "package sub\n")
+
+	tt := []struct {
+		name     string
+		patterns []string
+		bc       BuildContext
+		expected []File
+	}{
+		{
+			name:     "GOOS suffixes",
+			patterns: []string{"./..."},
+			bc:       BuildContext{GOOS: "windows", GOARCH: "amd64"},
+			expected: []File{
+				{Path: "a.go", ImportPath: "example.com/m"},
+				{Path: "a_windows.go", ImportPath: "example.com/m"},
+				{Path: "sub/b.go", ImportPath: "example.com/m/sub"},
+			},
+		},
+		{
+			name:     "build tags and tests",
+			patterns: []string{"."},
+			bc:       BuildContext{GOOS: "linux", GOARCH: "amd64", Tags: []string{"special"}, Tests: true},
+			expected: []File{
+				{Path: "a.go", ImportPath: "example.com/m"},
+				{Path: "a_linux.go", ImportPath: "example.com/m"},
+				{Path: "a_test.go", ImportPath: "example.com/m"},
+				{Path: "tagged.go", ImportPath: "example.com/m"},
+			},
+		},
+	}
+	for _, tt := range tt {
+		s.T().Run(tt.name, func(t *testing.T) {
+			tt.bc.Dir = s.tmpDir
+			files, err := LoadPackageFiles(tt.patterns, tt.bc)
+			require.NoError(t, err)
+			for i := range files {
+				files[i].Path, err = chunkPath(s.tmpDir, files[i].Path)
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.expected, files)
+		})
+	}
+
+	_, err := LoadPackageFiles([]string{"./missing"}, BuildContext{Dir: s.tmpDir})
+	assert.Error(s.T(), err, "Expected error for non-existent package")
+}
+
+func (s *GoSplitTestSuite) TestSplitter() {
+	s.writeFile("a.go", "package a\n\nfunc A() {}\n\ntype T struct{}\n")
+	s.writeFile("sub/b.go", "package sub\n\nfunc B() {}\n")
+	s.writeFile("testdata/c.go", "package c\n\nfunc C() {}\n")
+
+	splitter, err := New(WithTokenizer(s.tok), WithRoot(s.tmpDir))
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), s.tok, splitter.Tokenizer())
+
+	chunks, err := splitter.SplitDir(s.tmpDir)
+	require.NoError(s.T(), err)
+	var got []string
+	for _, chunk := range chunks {
+		got = append(got, chunk.Path+" "+chunk.Name)
+		assert.NotEmpty(s.T(), chunk.ID)
+		assert.Positive(s.T(), chunk.Size)
+		assert.Equal(s.T(), DefaultTokenizer, chunk.Tokenizer)
+	}
+	assert.Equal(s.T(), []string{"a.go A", "a.go T", "sub/b.go B"}, got)
+
+	chunks, err = splitter.SplitFile(filepath.Join(s.tmpDir, "sub", "b.go"))
+	require.NoError(s.T(), err)
+	require.Len(s.T(), chunks, 1)
+	assert.Equal(s.T(), "sub/b.go", chunks[0].Path)
+
+	// Sources are not read from disk, and the import path is part of the ID.
+	src := []byte("package a\n\nfunc A() {}\n")
+	chunks, err = splitter.SplitFileSource(File{Path: filepath.Join(s.tmpDir, "x.go"), ImportPath: "example.com/a"}, src)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), chunks, 1)
+	assert.Equal(s.T(), "x.go", chunks[0].Path)
+	assert.Equal(s.T(), "example.com/a", chunks[0].ImportPath)
+	unqualified, err := splitter.SplitSource(filepath.Join(s.tmpDir, "x.go"), src)
+	require.NoError(s.T(), err)
+	assert.NotEqual(s.T(), chunks[0].ID, unqualified[0].ID)
+
+	_, err = splitter.SplitSource("invalid.go", []byte("invalid go code"))
+	assert.Error(s.T(), err, "Expected error for invalid Go source")
+
+	// Filters are applied before splitting.
+	splitter, err = New(WithTokenizer(s.tok), WithChunkTypes(ChunkTypeFunction),
+		WithFilter(func(c *Chunk) bool { return c.Name != "B" }))
+	require.NoError(s.T(), err)
+	chunks, err = splitter.SplitDir(s.tmpDir)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), chunks, 1)
+	assert.Equal(s.T(), "A", chunks[0].Name)
+
+	long := "package a\n\nfunc Long() {\n" + strings.Repeat("\tprintln(\"hello, world\")\n", 20) + "}\n"
+	splitter, err = New(WithTokenizer(s.tok), WithChunkSize(40), WithChunkOverlap(5), WithRepeatHeader(true))
+	require.NoError(s.T(), err)
+	chunks, err = splitter.SplitSource("long.go", []byte(long))
+	require.NoError(s.T(), err)
+	require.Greater(s.T(), len(chunks), 1)
+	for _, chunk := range chunks {
+		assert.LessOrEqual(s.T(), chunk.Size, 40)
+		assert.Equal(s.T(), len(chunks), chunk.Parts)
+	}
+
+	_, err = New(WithTokenizer(s.tok), WithChunkOverlap(5))
+	assert.Error(s.T(), err, "Expected error for overlap without chunk size")
+}
+
+func (s *GoSplitTestSuite) TestSplitterSeq() {
+	aPath := s.writeFile("a.go", "package a\n\nfunc A() {}\n\nfunc B() {}\n")
+	s.writeFile("b.go", "package a\n\nfunc C() {}\n")
+
+	splitter, err := New(WithTokenizer(s.tok))
+	require.NoError(s.T(), err)
+
+	// Iteration can stop early.
+	var names []string
+	for chunk, err := range splitter.SplitDirSeq(s.tmpDir) {
+		require.NoError(s.T(), err)
+		names = append(names, chunk.Name)
+		if len(names) == 2 {
+			break
+		}
+	}
+	assert.Equal(s.T(), []string{"A", "B"}, names)
+
+	// Chunks are yielded before a later file fails, and the error ends the iteration.
+	names = nil
+	var errs []error
+	files := []File{{Path: aPath}, {Path: filepath.Join(s.tmpDir, "missing.go")}, {Path: aPath}}
+	for chunk, err := range splitter.SplitFilesSeq(files) {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		names = append(names, chunk.Name)
+	}
+	assert.Equal(s.T(), []string{"A", "B"}, names)
+	require.Len(s.T(), errs, 1)
+	assert.Contains(s.T(), errs[0].Error(), "missing.go")
+
+	_, err = splitter.SplitFiles(files)
+	assert.Error(s.T(), err)
+}
+
+func (s *GoSplitTestSuite) TestSplitterJobs() {
+	for i := range 20 {
+		var b strings.Builder
+		fmt.Fprintf(&b, "package p\n")
+		for j := range i + 1 {
+			fmt.Fprintf(&b, "\nfunc F%d_%d() {\n\tprintln(%d)\n}\n", i, j, j)
+		}
+		s.writeFile(fmt.Sprintf("p/f%02d.go", i), b.String())
+	}
+
+	sequential, err := New(WithTokenizer(s.tok), WithChunkSize(8))
+	require.NoError(s.T(), err)
+	expected, err := sequential.SplitDir(s.tmpDir)
+	require.NoError(s.T(), err)
+	require.Greater(s.T(), len(expected), 20*21/2, "Expected functions to be split")
+
+	concurrent, err := New(WithTokenizer(s.tok), WithChunkSize(8), WithJobs(4))
+	require.NoError(s.T(), err)
+	for range 3 {
+		chunks, err := concurrent.SplitDir(s.tmpDir)
+		require.NoError(s.T(), err)
+		assert.Equal(s.T(), expected, chunks)
+	}
+
+	// Iteration can stop early while files are being split.
+	n := 0
+	for _, err := range concurrent.SplitDirSeq(s.tmpDir) {
+		require.NoError(s.T(), err)
+		n++
+		if n == 3 {
+			break
+		}
+	}
+	assert.Equal(s.T(), 3, n)
+
+	// The chunks of the files before a failing file are yielded.
+	first := filepath.Join(s.tmpDir, "p", "f00.go")
+	files := []File{{Path: first}, {Path: filepath.Join(s.tmpDir, "missing.go")}}
+	var yielded []*Chunk
+	var errs []error
+	for chunk, err := range concurrent.SplitFilesSeq(files) {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		yielded = append(yielded, chunk)
+	}
+	firstChunks, err := sequential.SplitFile(first)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), firstChunks, yielded)
+	assert.Len(s.T(), errs, 1)
+}
+
+func (s *GoSplitTestSuite) TestFileContext() {
+	src := []byte(`package server
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/example/go-bar/v2"
+	yaml "gopkg.in/yaml.v3"
+)
+
+func Handle(w http.ResponseWriter, db *sql.DB) {
+	fmt.Fprintln(w, bar.X)
+}
+
+type Config struct {
+	Doc yaml.Node
+}
+
+const Version = "1"
+`)
+
+	none, err := New(WithTokenizer(s.tok))
+	require.NoError(s.T(), err)
+	plain, err := none.SplitSource("server.go", src)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), plain, 3)
+	assert.Equal(s.T(), []string{"database/sql", "fmt", "net/http", "github.com/example/go-bar/v2"}, plain[0].Imports)
+	assert.Equal(s.T(), []string{"gopkg.in/yaml.v3"}, plain[1].Imports)
+	assert.Nil(s.T(), plain[2].Imports)
+
+	field, err := New(WithTokenizer(s.tok), WithContext(ContextField))
+	require.NoError(s.T(), err)
+	chunks, err := field.SplitSource("server.go", src)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), chunks, 3)
+	assert.Equal(s.T(), "package server\n\nimport (\n\t\"database/sql\"\n\t\"fmt\"\n\t\"net/http\"\n\t\"github.com/example/go-bar/v2\"\n)", chunks[0].Context)
+	assert.Equal(s.T(), "package server\n\nimport yaml \"gopkg.in/yaml.v3\"", chunks[1].Context)
+	assert.Equal(s.T(), "package server", chunks[2].Context)
+	for i, chunk := range chunks {
+		assert.Equal(s.T(), plain[i].Content, chunk.Content)
+		assert.Equal(s.T(), plain[i].Size, chunk.Size)
+		assert.Empty(s.T(), plain[i].Context)
+		assert.NotEqual(s.T(), plain[i].ID, chunk.ID, "the file context is part of the ID")
+	}
+
+	prefix, err := New(WithTokenizer(s.tok), WithContext(ContextPrefix))
+	require.NoError(s.T(), err)
+	prefixed, err := prefix.SplitSource("server.go", src)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), prefixed, 3)
+	for i, chunk := range prefixed {
+		assert.Equal(s.T(), chunks[i].Context+"\n\n"+plain[i].Content, chunk.Content)
+		assert.Empty(s.T(), chunk.Context)
+		size, err := s.tok.Count(chunk.Content)
+		require.NoError(s.T(), err)
+		assert.Equal(s.T(), size, chunk.Size)
+	}
+
+	// Every part of a split chunk starts with the file context and fits into the chunk size.
+	long := "package server\n\nimport \"fmt\"\n\nfunc Long() {\n" + strings.Repeat("\tfmt.Println(\"hello, world\")\n", 20) + "}\n"
+	prefix, err = New(WithTokenizer(s.tok), WithContext(ContextPrefix), WithChunkSize(40))
+	require.NoError(s.T(), err)
+	parts, err := prefix.SplitSource("long.go", []byte(long))
+	require.NoError(s.T(), err)
+	require.Greater(s.T(), len(parts), 1)
+	for _, part := range parts {
+		assert.True(s.T(), strings.HasPrefix(part.Content, "package server\n\nimport \"fmt\"\n\n"), part.Content)
+		assert.LessOrEqual(s.T(), part.Size, 40)
+	}
+
+	// Unsplit chunks with a file context can be split later, as by the diff command.
+	unsplit, err := New(WithTokenizer(s.tok), WithContext(ContextPrefix))
+	require.NoError(s.T(), err)
+	whole, err := unsplit.SplitSource("long.go", []byte(long))
+	require.NoError(s.T(), err)
+	require.Len(s.T(), whole, 1)
+	assert.Equal(s.T(), whole[0].ID, parts[0].ParentID)
+	split, err := prefix.SplitChunk(whole[0])
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), parts, split)
+
+	prefix, err = New(WithTokenizer(s.tok), WithContext(ContextPrefix), WithChunkSize(3))
+	require.NoError(s.T(), err)
+	_, err = prefix.SplitSource("long.go", []byte(long))
+	assert.Error(s.T(), err, "Expected error for a file context exceeding the chunk size")
+
+	// The overlap is scaled to the tokens left after the file context, so that every part still
+	// carries over new code.
+	header := "package server\n\nimport \"fmt\"\n\n"
+	headerSize, err := s.tok.Count(header)
+	require.NoError(s.T(), err)
+	prefix, err = New(WithTokenizer(s.tok), WithContext(ContextPrefix), WithChunkSize(40), WithChunkOverlap(20))
+	require.NoError(s.T(), err)
+	overlapped, err := prefix.SplitSource("long.go", []byte(long))
+	require.NoError(s.T(), err)
+	require.Greater(s.T(), len(overlapped), 1)
+	assert.LessOrEqual(s.T(), len(overlapped), 3*len(parts))
+	for i, part := range overlapped {
+		require.True(s.T(), strings.HasPrefix(part.Content, header), part.Content)
+		assert.LessOrEqual(s.T(), part.Size, 40)
+		if i > 0 {
+			code := strings.TrimPrefix(part.Content, header)
+			assert.NotContains(s.T(), strings.TrimPrefix(overlapped[i-1].Content, header), code)
+		}
+	}
+
+	prefix, err = New(WithTokenizer(s.tok), WithContext(ContextPrefix), WithChunkSize(headerSize+2), WithChunkOverlap(headerSize+1))
+	require.NoError(s.T(), err)
+	_, err = prefix.SplitSource("long.go", []byte(long))
+	assert.Error(s.T(), err, "Expected error for an overlap leaving no room for code after the file context")
+
+	_, err = New(WithTokenizer(s.tok), WithContext("unknown"))
+	assert.Error(s.T(), err)
+}
+
+func (s *GoSplitTestSuite) TestAssumedPackageName() {
+	for path, expected := range map[string]string{
+		"fmt":                          "fmt",
+		"net/http":                     "http",
+		"github.com/mattn/go-sqlite3":  "sqlite3",
+		"github.com/example/foo/v2":    "foo",
+		"gopkg.in/yaml.v3":             "yaml",
+		"github.com/example/v0":        "v0",
+		"github.com/example/kebab-pkg": "kebab",
+	} {
+		assert.Equal(s.T(), expected, assumedPackageName(path), path)
+	}
+}
+
+func (s *GoSplitTestSuite) TestImports() {
+	parsed, err := processSource("imports.go", []byte(`package p
+
+import (
+	_ "embed"
+	"fmt"
+	yaml "gopkg.in/yaml.v3"
+	. "strings"
+	"net/http"
+)
+
+func Dot(s string) string {
+	return ToUpper(s)
+}
+
+func Named(v any) ([]byte, error) {
+	return yaml.Marshal(v)
+}
+
+func Shadowed(fmt Printer) {
+	fmt.Println("not the fmt package")
+}
+
+func Both(w http.ResponseWriter) {
+	fmt.Fprintln(w, Repeat("-", 3))
+}
+`))
+	require.NoError(s.T(), err)
+	chunks := extractChunks(parsed.file, parsed.src, parsed.fset)
+	require.Len(s.T(), chunks, 4)
+
+	// Dot imports are not referenced by selectors, and blank imports are never referenced.
+	assert.Nil(s.T(), chunks[0].Imports)
+	assert.Equal(s.T(), []string{"gopkg.in/yaml.v3"}, chunks[1].Imports)
+	// Without type information, a local variable shadowing a package name is taken for the package.
+	assert.Equal(s.T(), []string{"fmt"}, chunks[2].Imports)
+	// Imports are listed in the order they are imported.
+	assert.Equal(s.T(), []string{"fmt", "net/http"}, chunks[3].Imports)
+}
+
+func (s *GoSplitTestSuite) TestGetCalls() {
+	parsed, err := processSource("calls.go", []byte(`package p
+
+import (
+	"fmt"
+	str "strings"
+)
+
+func Run(s *Server, names []string) error {
+	defer s.Close()
+	for _, name := range names {
+		if len(name) == 0 || str.HasPrefix(name, "_") {
+			continue
+		}
+		fmt.Println(Map[string, int](name, count))
+		s.store.Get(name).Close()
+	}
+	func() { helper() }()
+	_ = []byte(fmt.Sprint(s.Close()))
+	return nil
+}
+
+func Empty() {}
+`))
+	require.NoError(s.T(), err)
+	chunks := extractChunks(parsed.file, parsed.src, parsed.fset)
+	require.Len(s.T(), chunks, 2)
+	assert.Equal(s.T(), []string{"s.Close", "str.HasPrefix", "fmt.Println", "Map", "s.store.Get(name).Close", "s.store.Get", "helper", "fmt.Sprint"}, chunks[0].Calls)
+	assert.Nil(s.T(), chunks[1].Calls)
+
+	assert.Equal(s.T(), []callee{
+		{method: true, name: "Close"},
+		{pkg: "strings", name: "HasPrefix"},
+		{pkg: "fmt", name: "Println"},
+		{name: "Map"},
+		{method: true, name: "Get"},
+		{name: "helper"},
+		{pkg: "fmt", name: "Sprint"},
+	}, parsed.callees(parsed.file.Decls[1]))
+}
+
+func (s *GoSplitTestSuite) TestSplitterCallers() {
+	a := s.writeFile("p/a.go", `package p
+
+import "example.com/m/q"
+
+func Run(s *Server) {
+	helper()
+	s.Close()
+	q.Do()
+}
+
+func helper() {
+	helper()
+}
+`)
+	b := s.writeFile("p/b.go", `package p
+
+type Server struct{}
+
+func (s *Server) Close() {}
+
+func Close() {}
+`)
+	c := s.writeFile("q/q.go", `package q
+
+func Do() {}
+
+func helper() {}
+`)
+	files := []File{
+		{Path: a, ImportPath: "example.com/m/p"},
+		{Path: b, ImportPath: "example.com/m/p"},
+		{Path: c, ImportPath: "example.com/m/q"},
+	}
+
+	splitter, err := New(WithTokenizer(s.tok), WithCallers(true), WithJobs(2))
+	require.NoError(s.T(), err)
+	chunks, err := splitter.SplitFiles(files)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), chunks, 7)
+	run, helper, server, closeMethod, closeFunc, do, otherHelper := chunks[0], chunks[1], chunks[2], chunks[3], chunks[4], chunks[5], chunks[6]
+	assert.Equal(s.T(), []string{"helper", "s.Close", "q.Do"}, run.Calls)
+	assert.Nil(s.T(), run.CalledBy)
+	assert.Equal(s.T(), []string{run.ID, helper.ID}, helper.CalledBy)
+	assert.Nil(s.T(), server.CalledBy)
+	assert.Equal(s.T(), []string{run.ID}, closeMethod.CalledBy)
+	assert.Nil(s.T(), closeFunc.CalledBy)
+	assert.Equal(s.T(), []string{run.ID}, do.CalledBy)
+	assert.Nil(s.T(), otherHelper.CalledBy)
+
+	// Without import paths, calls of other packages are not matched.
+	chunks, err = splitter.SplitDir(s.tmpDir)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), chunks, 7)
+	assert.Equal(s.T(), []string{chunks[0].ID, chunks[1].ID}, chunks[1].CalledBy)
+	assert.Nil(s.T(), chunks[5].CalledBy)
+
+	// A split caller is recorded once by the ID of the original chunk, and only the first part
+	// of a split function records its callers.
+	d := s.writeFile("r/r.go", "package r\n\nfunc Long() {\n"+strings.Repeat("\tshort()\n", 10)+"\tLong()\n}\n\nfunc short() {}\n")
+	splitter, err = New(WithTokenizer(s.tok), WithCallers(true), WithChunkSize(30))
+	require.NoError(s.T(), err)
+	chunks, err = splitter.SplitFiles([]File{{Path: d, ImportPath: "example.com/m/r"}})
+	require.NoError(s.T(), err)
+	require.Greater(s.T(), len(chunks), 3)
+	long, short := chunks[:len(chunks)-1], chunks[len(chunks)-1]
+	parentID := long[0].ParentID
+	require.NotEmpty(s.T(), parentID)
+	assert.Equal(s.T(), []string{parentID}, short.CalledBy)
+	assert.Equal(s.T(), []string{parentID}, long[0].CalledBy)
+	for _, part := range long[1:] {
+		assert.Equal(s.T(), parentID, part.ParentID)
+		assert.Nil(s.T(), part.CalledBy)
+	}
+
+	// The callers are only recorded on request.
+	splitter, err = New(WithTokenizer(s.tok))
+	require.NoError(s.T(), err)
+	chunks, err = splitter.SplitFiles(files)
+	require.NoError(s.T(), err)
+	for _, chunk := range chunks {
+		assert.Nil(s.T(), chunk.CalledBy)
+	}
+}
+
+func (s *GoSplitTestSuite) TestTypeCheck() {
+	a := s.writeFile("p/a.go", `package p
+
+import (
+	"strings"
+
+	"example.com/missing/bar"
+)
+
+type Server struct{}
+
+func (s *Server) Close() error { return nil }
+
+func (s *Server) Run(x bar.Thing) {
+	helper(strings.ToUpper(x.Name()))
+	s.Close()
+}
+`)
+	b := s.writeFile("p/b.go", `package p
+
+var names []string
+
+func helper(name string) int { return len(names) }
+
+type Store interface {
+	Close() error
+}
+
+func use(st Store) {
+	st.Close()
+}
+`)
+	files := []File{{Path: a, ImportPath: "example.com/m/p"}, {Path: b, ImportPath: "example.com/m/p"}}
+
+	splitter, err := New(WithTokenizer(s.tok), WithTypeCheck(true), WithCallers(true))
+	require.NoError(s.T(), err)
+	chunks, err := splitter.SplitFiles(files)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), chunks, 7)
+	server, closeMethod, run, names, helper, store, use := chunks[0], chunks[1], chunks[2], chunks[3], chunks[4], chunks[5], chunks[6]
+
+	assert.Equal(s.T(), "*example.com/m/p.Server", closeMethod.ReceiverType)
+	assert.Equal(s.T(), "func (*example.com/m/p.Server).Close() error", closeMethod.Signature)
+	assert.Equal(s.T(), "func example.com/m/p.helper(name string) int", helper.Signature)
+	assert.Equal(s.T(), []string{"example.com/m/p.names"}, helper.References)
+	assert.Equal(s.T(), []string{"example.com/m/p.Store", "(example.com/m/p.Store).Close"}, use.References)
+	assert.Empty(s.T(), server.Signature)
+	assert.Empty(s.T(), names.References)
+
+	// The signature involving a type of a missing dependency is left out, while the
+	// references that could be resolved are recorded.
+	assert.Equal(s.T(), "*example.com/m/p.Server", run.ReceiverType)
+	assert.Empty(s.T(), run.Signature)
+	assert.Equal(s.T(), []string{
+		"example.com/m/p.Server",
+		"example.com/m/p.helper",
+		"strings.ToUpper",
+		"(*example.com/m/p.Server).Close",
+	}, run.References)
+
+	// The call of the interface method is not taken for a call of the method of Server.
+	assert.Equal(s.T(), []string{run.ID}, closeMethod.CalledBy)
+	assert.Equal(s.T(), []string{run.ID}, helper.CalledBy)
+	assert.Nil(s.T(), store.CalledBy)
+
+	// A file split on its own is type-checked as a package of its own.
+	chunks, err = splitter.SplitFile(a)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), chunks, 3)
+	assert.Equal(s.T(), "func (*"+filepath.ToSlash(filepath.Dir(a))+".Server).Close() error", chunks[1].Signature)
+	assert.NotContains(s.T(), chunks[2].References, filepath.ToSlash(filepath.Dir(a))+".helper")
+	assert.Contains(s.T(), chunks[2].References, "strings.ToUpper")
+
+	// Without type checking, no type information is recorded.
+	splitter, err = New(WithTokenizer(s.tok))
+	require.NoError(s.T(), err)
+	chunks, err = splitter.SplitFiles(files)
+	require.NoError(s.T(), err)
+	for _, chunk := range chunks {
+		assert.Empty(s.T(), chunk.Signature)
+		assert.Empty(s.T(), chunk.ReceiverType)
+		assert.Nil(s.T(), chunk.References)
+	}
+}
+
+func (s *GoSplitTestSuite) TestTypeCheckModule() {
+	s.writeFile("go.mod", "module example.com/m\n\ngo 1.24\n")
+	p := s.writeFile("p/p.go", `package p
+
+type Thing struct{}
+
+func Make() Thing { return Thing{} }
+`)
+	s.writeFile("p/extra.go", `//go:build extra
+
+package p
+
+type Extra struct{}
+`)
+	q := s.writeFile("q/q.go", `package q
+
+import "example.com/m/p"
+
+func Use() p.Thing { return p.Make() }
+
+func UseExtra(e p.Extra) {}
+`)
+	files := []File{{Path: p}, {Path: q}}
+
+	// Without import paths, packages are checked with the import paths of their directories,
+	// so that they match the packages imported by other packages.
+	splitter, err := New(WithTokenizer(s.tok), WithTypeCheck(true), WithCallers(true))
+	require.NoError(s.T(), err)
+	chunks, err := splitter.SplitFiles(files)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), chunks, 4)
+	thing, makeThing, use, useExtra := chunks[0], chunks[1], chunks[2], chunks[3]
+	assert.Equal(s.T(), "func example.com/m/p.Make() example.com/m/p.Thing", makeThing.Signature)
+	assert.Equal(s.T(), []string{"example.com/m/p.Thing", "example.com/m/p.Make"}, use.References)
+	assert.Equal(s.T(), []string{use.ID}, makeThing.CalledBy)
+	assert.Nil(s.T(), thing.CalledBy)
+	assert.Empty(s.T(), useExtra.Signature)
+
+	// Dependencies are imported for the build tags of the build context.
+	splitter, err = New(WithTokenizer(s.tok), WithTypeCheck(true), WithBuildContext(BuildContext{Tags: []string{"extra"}}))
+	require.NoError(s.T(), err)
+	chunks, err = splitter.SplitFiles(files[1:])
+	require.NoError(s.T(), err)
+	require.Len(s.T(), chunks, 2)
+	assert.Equal(s.T(), "func example.com/m/q.UseExtra(e example.com/m/p.Extra)", chunks[1].Signature)
+}
+
+func (s *GoSplitTestSuite) TestTokenizers() {
+	text := "\tname := \"héllo  world\" "
+	tests := []struct {
+		name  string
+		count int
+	}{
+		{name: "bytes", count: len(text)},
+		{name: "chars", count: len(text) - 1},
+		{name: "words", count: 4},
+		{name: "cl100k_base"},
+		{name: "o200k_base"},
+	}
+	for _, tt := range tests {
+		s.Run(tt.name, func() {
+			tok, err := NewTokenizer(tt.name, "")
+			require.NoError(s.T(), err)
+			assert.Equal(s.T(), tt.name, tok.Name())
+
+			count, err := tok.Count(text)
+			require.NoError(s.T(), err)
+			if tt.count > 0 {
+				assert.Equal(s.T(), tt.count, count)
+			}
+
+			// The offsets cut text into its tokens at character boundaries.
+			offsets, err := tok.Offsets(text)
+			require.NoError(s.T(), err)
+			require.Len(s.T(), offsets, count)
+			assert.Equal(s.T(), len(text), offsets[len(offsets)-1])
+			for i, offset := range offsets {
+				if i > 0 {
+					assert.LessOrEqual(s.T(), offsets[i-1], offset)
+				}
+				assert.True(s.T(), utf8.ValidString(text[:offset]), "offset %d", offset)
+			}
+		})
+	}
+
+	tok, err := NewTokenizer("words", "")
+	require.NoError(s.T(), err)
+	suffix, err := tokenSuffix(tok, text, 2)
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), " \"héllo  world\" ", suffix)
+
+	_, err = NewTokenizer("unknown", "")
+	assert.ErrorContains(s.T(), err, "o200k_base")
+}
+
+func (s *GoSplitTestSuite) TestBpeLoader() {
+	// A rank file with a token per byte, so that every byte is a token.
+	var ranks strings.Builder
+	for i := 0; i < 256; i++ {
+		fmt.Fprintf(&ranks, "%s %d\n", base64.StdEncoding.EncodeToString([]byte{byte(i)}), i)
+	}
+	bpeFile := s.writeFile("bytes.tiktoken", ranks.String())
+	url := "https://openaipublic.blob.core.windows.net/encodings/r50k_base.tiktoken"
+
+	loaded, err := (&bpeLoader{file: bpeFile}).LoadTiktokenBpe(url)
+	require.NoError(s.T(), err)
+	assert.Len(s.T(), loaded, 256)
+	assert.Equal(s.T(), 97, loaded["a"])
+
+	// Without a local file, embedded or cached ranks, loading fails instead of downloading.
+	s.T().Setenv("TIKTOKEN_CACHE_DIR", s.T().TempDir())
+	_, err = (&bpeLoader{}).LoadTiktokenBpe(url)
+	assert.ErrorContains(s.T(), err, "--bpe-file")
+
+	invalid := s.writeFile("invalid.tiktoken", "YQ== x\n")
+	_, err = (&bpeLoader{file: invalid}).LoadTiktokenBpe(url)
+	assert.Error(s.T(), err)
+
+	tok, err := NewTokenizer("r50k_base", bpeFile)
+	require.NoError(s.T(), err)
+	count, err := tok.Count("abc")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 3, count)
+
+	// Encodings are cached by BPE file, so that another file of the same encoding is honored.
+	ranks.WriteString(base64.StdEncoding.EncodeToString([]byte("ab")) + " 256\n")
+	merged, err := NewTokenizer("r50k_base", s.writeFile("merged.tiktoken", ranks.String()))
+	require.NoError(s.T(), err)
+	count, err = merged.Count("abc")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 2, count)
+	count, err = tok.Count("abc")
+	require.NoError(s.T(), err)
+	assert.Equal(s.T(), 3, count)
+
+	// Tokenizers can be created concurrently.
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := NewTokenizer("p50k_edit", bpeFile)
+			assert.NoError(s.T(), err)
+		}()
+	}
+	wg.Wait()
+
+	_, err = NewTokenizer("words", bpeFile)
+	assert.Error(s.T(), err)
+}
+
+func (s *GoSplitTestSuite) TestHFTokenizer() {
+	// A byte-level BPE vocabulary with every byte and a few merges, as used by GPT-2.
+	vocab := map[string]int{}
+	for b := 0; b < 256; b++ {
+		vocab[string(byteLevelRune(byte(b)))] = b
+	}
+	for _, token := range []string{"fu", "fun", "func", "Ġx"} {
+		vocab[token] = len(vocab)
+	}
+	bpeVocab, err := json.Marshal(vocab)
+	require.NoError(s.T(), err)
+
+	tests := []struct {
+		name    string
+		config  string
+		text    string
+		offsets []int
+	}{
+		{
+			name: "byte-level BPE",
+			config: `{
+				"added_tokens": [{"id": 300, "content": "<|end|>", "special": true}],
+				"pre_tokenizer": {"type": "ByteLevel", "add_prefix_space": false, "use_regex": true},
+				"model": {"type": "BPE", "vocab": ` + string(bpeVocab) + `, "merges": ["f u", ["fu", "n"], "fun c", "Ġ x"]}
+			}`,
+			text:    "func x()é<|end|>",
+			offsets: []int{4, 6, 7, 8, 10, 10, 17},
+		},
+		{
+			name: "WordPiece",
+			config: `{
+				"normalizer": {"type": "BertNormalizer", "lowercase": true},
+				"pre_tokenizer": {"type": "BertPreTokenizer"},
+				"model": {
+					"type": "WordPiece",
+					"unk_token": "[UNK]",
+					"continuing_subword_prefix": "##",
+					"vocab": {"[UNK]": 0, "func": 1, "##tion": 2, "hello": 3, "(": 4, ")": 5}
+				}
+			}`,
+			text:    "Function Hello() xyz ",
+			offsets: []int{4, 8, 14, 15, 16, 21},
+		},
+		{
+			name: "Unigram",
+			config: `{
+				"normalizer": {"type": "Sequence", "normalizers": [{"type": "NFKC"}, {"type": "Replace", "pattern": {"String": "  "}, "content": " "}]},
+				"pre_tokenizer": {"type": "Metaspace", "replacement": "▁", "prepend_scheme": "always", "split": true},
+				"model": {
+					"type": "Unigram",
+					"unk_id": 0,
+					"vocab": [["<unk>", 0], ["▁", -2], ["▁foo", -1], ["bar", -1.5], ["a", -3], ["b", -3], ["r", -3]]
+				}
+			}`,
+			text:    "foo  bar qq",
+			offsets: []int{3, 5, 8, 9, 11},
+		},
+		{
+			name: "BPE with fused unknown tokens",
+			config: `{
+				"pre_tokenizer": {"type": "WhitespaceSplit"},
+				"model": {"type": "BPE", "unk_token": "<unk>", "fuse_unk": true, "vocab": {"<unk>": 0, "a": 1, "b": 2, "ab": 3}, "merges": ["a b"]}
+			}`,
+			text:    "abxyab a?b",
+			offsets: []int{2, 4, 6, 8, 9, 10},
+		},
+		{
+			// Byte tokens of the same character all end with the character.
+			name: "BPE with byte fallback",
+			config: `{
+				"pre_tokenizer": {"type": "WhitespaceSplit"},
+				"model": {"type": "BPE", "unk_token": "<unk>", "byte_fallback": true, "vocab": {"<unk>": 0, "a": 1, "<0xC3>": 2, "<0xA9>": 3}, "merges": []}
+			}`,
+			text:    "aéx",
+			offsets: []int{1, 3, 3, 4},
+		},
+	}
+	for _, tt := range tests {
+		s.Run(tt.name, func() {
+			file := s.writeFile("bert-base/tokenizer.json", tt.config)
+			tok, err := NewHFTokenizer(file)
+			require.NoError(s.T(), err)
+			assert.Equal(s.T(), "huggingface:bert-base", tok.Name())
+
+			offsets, err := tok.Offsets(tt.text)
+			require.NoError(s.T(), err)
+			assert.Equal(s.T(), tt.offsets, offsets)
+
+			count, err := tok.Count(tt.text)
+			require.NoError(s.T(), err)
+			assert.Equal(s.T(), len(tt.offsets), count)
+		})
+	}
+
+	// Long lines split with byte fallback tokens have no empty parts and no partial characters.
+	fallback := s.writeFile("fallback/tokenizer.json", tests[len(tests)-1].config)
+	tok, err := NewHFTokenizer(fallback)
+	require.NoError(s.T(), err)
+	line := "a" + strings.Repeat("é", 20)
+	parts, err := splitChunk(&Chunk{Content: line}, tok, 5, splitOptions{})
+	require.NoError(s.T(), err)
+	require.Greater(s.T(), len(parts), 1)
+	var joined string
+	for _, part := range parts {
+		assert.NotEmpty(s.T(), part.Content)
+		assert.True(s.T(), utf8.ValidString(part.Content), part.Content)
+		assert.LessOrEqual(s.T(), part.Size, 5)
+		joined += part.Content
+	}
+	assert.Equal(s.T(), line, joined)
+
+	unsupported := s.writeFile("unsupported.json", `{"model": {"type": "Unknown"}}`)
+	_, err = NewHFTokenizer(unsupported)
+	assert.ErrorContains(s.T(), err, "unsupported tokenizer model")
+}
+
+func generateContentWithTokens(t *testing.T, tokens int) string {
+	if tokens == 0 {
+		return ""
+	}
+
+	encoding, err := getEncoding(DefaultTokenizer, filepath.Join("testdata", "ranks.tiktoken"))
+	require.NoError(t, err, "Failed to get encoding")
+
+	// Use a single word that we know the token count of
+	word := "token"
+	wordTokens := len(encoding.Encode(word, nil, nil))
+
+	// Calculate how many words we need
+	wordsNeeded := (tokens + wordTokens - 1) / wordTokens
+
+	// Generate the content with the exact number of words needed
+	content := strings.Repeat(word+" ", wordsNeeded-1) + word
+
+	// Verify the token count
+	tokenCount := len(encoding.Encode(content, nil, nil))
+	if tokenCount != tokens {
+		// If we're off by one, adjust by adding or removing a space
+		if tokenCount > tokens {
+			content = strings.TrimSuffix(content, " ")
+		} else {
+			content += " "
+		}
+	}
+
+	return content
+}
+
+func generateChunk(t *testing.T, lineSizes []int) *Chunk {
+	var (
+		content string
+		sum     int
+	)
+	for _, lineSize := range lineSizes {
+		content += generateContentWithTokens(t, lineSize) + "\n"
+		sum += lineSize
+	}
+	return &Chunk{Content: content, Size: sum}
+}
+
+func (s *GoSplitTestSuite) TestSplitChunk() {
+	// Every line is followed by a newline, which takes a token of its own.
+	tt := []struct {
+		lineSizes      []int
+		maxTokens      int
+		expectedChunks int
+	}{
+		{lineSizes: []int{9}, maxTokens: 10, expectedChunks: 1},
+		{lineSizes: []int{10}, maxTokens: 10, expectedChunks: 1},
+		{lineSizes: []int{11}, maxTokens: 10, expectedChunks: 2},
+		{lineSizes: []int{10, 4}, maxTokens: 15, expectedChunks: 1},
+		{lineSizes: []int{10, 5}, maxTokens: 15, expectedChunks: 2},
+		{lineSizes: []int{10, 6}, maxTokens: 15, expectedChunks: 2},
+		{lineSizes: []int{10, 14}, maxTokens: 15, expectedChunks: 2},
+		{lineSizes: []int{10, 15}, maxTokens: 15, expectedChunks: 2},
+		{lineSizes: []int{10, 16}, maxTokens: 15, expectedChunks: 3},
+		{lineSizes: []int{99}, maxTokens: 0, expectedChunks: 1},
+		// Edge cases
+		{lineSizes: []int{0}, maxTokens: 10, expectedChunks: 1},          // Empty content
+		{lineSizes: []int{0, 0, 0}, maxTokens: 10, expectedChunks: 1},    // Multiple empty lines
+		{lineSizes: []int{20}, maxTokens: 5, expectedChunks: 4},          // Single long line
+		{lineSizes: []int{20, 20, 20}, maxTokens: 5, expectedChunks: 12}, // Multiple long lines
+		{lineSizes: []int{5, 0, 5}, maxTokens: 12, expectedChunks: 1},    // Lines with empty line in between
+		{lineSizes: []int{5, 0, 5}, maxTokens: 11, expectedChunks: 2},
+	}
+
+	for _, tt := range tt {
+		s.T().Run(fmt.Sprintf("chunkSizes=%+v, maxTokens=%d", tt.lineSizes, tt.maxTokens), func(t *testing.T) {
+			original := generateChunk(t, tt.lineSizes)
+			chunks, err := splitChunk(original, s.tok, tt.maxTokens, splitOptions{})
+			assert.NoError(t, err)
+			assert.Len(t, chunks, tt.expectedChunks)
+			for _, chunk := range chunks {
+				if tt.maxTokens > 0 {
+					assert.LessOrEqual(t, chunk.Size, tt.maxTokens)
+				}
+				if len(chunks) > 1 {
+					size, err := s.tok.Count(chunk.Content)
+					require.NoError(t, err)
+					assert.Equal(t, size, chunk.Size)
+				}
+				assert.Contains(t, original.Content, chunk.Content)
+			}
+		})
+	}
+}
+
+func (s *GoSplitTestSuite) TestSplitChunkRepeatHeader() {
+	const (
+		header = "// Long prints a lot.\nfunc Long() {"
+		stmt   = "\tfmt.Println(\"token token token\")"
+	)
+	content := header + "\n" + strings.Repeat(stmt+"\n", 20) + "}"
+
+	headerTokens, err := s.tok.Count(header + "\n")
+	require.NoError(s.T(), err)
+	stmtTokens, err := s.tok.Count(stmt)
+	require.NoError(s.T(), err)
+	markerTokens, err := s.tok.Count(fmt.Sprintf(partMarkerFormat, 999, 999) + "\n")
+	require.NoError(s.T(), err)
+	maxTokens := 2*(headerTokens+markerTokens) + 3*stmtTokens
+
+	for _, repeatHeader := range []bool{false, true} {
+		s.T().Run(fmt.Sprintf("repeatHeader=%t", repeatHeader), func(t *testing.T) {
+			chunks, err := splitChunk(&Chunk{Content: content}, s.tok, maxTokens, splitOptions{RepeatHeader: repeatHeader})
+			require.NoError(t, err)
+			require.Greater(t, len(chunks), 2)
+
+			assert.True(t, strings.HasPrefix(chunks[0].Content, header+"\n"+stmt))
+			for i, chunk := range chunks[1:] {
+				size, err := s.tok.Count(chunk.Content)
+				require.NoError(t, err)
+				assert.Equal(t, size, chunk.Size)
+				assert.LessOrEqual(t, chunk.Size, maxTokens)
+				body := chunk.Content
+				if repeatHeader {
+					prefix := header + "\n" + fmt.Sprintf(partMarkerFormat, i+2, len(chunks)) + "\n"
+					require.True(t, strings.HasPrefix(body, prefix), "part %d: %q", i+2, body)
+					body = strings.TrimPrefix(body, prefix)
+				}
+				// The body of every part consists of whole statements.
+				for _, line := range strings.Split(body, "\n") {
+					assert.Contains(t, []string{stmt, "}"}, line)
+				}
+			}
+		})
+	}
+}
+
+func (s *GoSplitTestSuite) TestSplitChunkOverlap() {
+	const overlap = 3
+	original := generateChunk(s.T(), []int{8, 8, 8, 8, 8, 8})
+
+	chunks, err := splitChunk(original, s.tok, 20, splitOptions{Overlap: overlap})
+	require.NoError(s.T(), err)
+	require.Greater(s.T(), len(chunks), 2)
+
+	for i, chunk := range chunks {
+		assert.LessOrEqual(s.T(), chunk.Size, 20)
+		if i == 0 {
+			assert.True(s.T(), strings.HasPrefix(original.Content, chunk.Content))
+			continue
+		}
+		carried, err := tokenSuffix(s.tok, chunks[i-1].Content, overlap)
+		require.NoError(s.T(), err)
+		carriedTokens, err := s.tok.Count(carried)
+		require.NoError(s.T(), err)
+		assert.Equal(s.T(), overlap, carriedTokens)
+		require.True(s.T(), strings.HasPrefix(chunk.Content, carried+"\n"), "part %d: %q", i+1, chunk.Content)
+
+		// The size of a part is the number of tokens of its content, including the carried tokens.
+		size, err := s.tok.Count(chunk.Content)
+		require.NoError(s.T(), err)
+		assert.Equal(s.T(), size, chunk.Size)
+		assert.Contains(s.T(), original.Content, chunk.Content)
+	}
+
+	_, err = splitChunk(original, s.tok, 20, splitOptions{Overlap: 20})
+	assert.Error(s.T(), err, "Expected error for overlap not smaller than the chunk size")
+
+	// Room is left for the newline between the carried tokens and the part.
+	bytes, err := NewTokenizer("bytes", "")
+	require.NoError(s.T(), err)
+	chunks, err = splitChunk(&Chunk{Content: "aaaa\nbbbb\ncccc\ndd"}, bytes, 9, splitOptions{Overlap: 2})
+	require.NoError(s.T(), err)
+	contents := make([]string, 0, len(chunks))
+	for _, chunk := range chunks {
+		assert.Equal(s.T(), len(chunk.Content), chunk.Size)
+		contents = append(contents, chunk.Content)
+	}
+	assert.Equal(s.T(), []string{"aaaa\nbbbb", "bb\ncccc", "cc\ndd"}, contents)
+}
+
+func (s *GoSplitTestSuite) TestSplitChunkMetadata() {
+	const stmt = "\tfmt.Println(\"token token token\")"
+	content := "func Long() {\n" + strings.Repeat(stmt+"\n", 20) + "}"
+	lines := strings.Split(content, "\n")
+	original := &Chunk{
+		Content: content,
+		Type:    ChunkTypeFunction,
+		Name:    "Long",
+		Path:    "long.go",
+		Start:   10,
+		End:     31,
+	}
+	setChunkID(original)
+
+	stmtTokens, err := s.tok.Count(stmt)
+	require.NoError(s.T(), err)
+	chunks, err := splitChunk(original, s.tok, 4*stmtTokens, splitOptions{})
+	require.NoError(s.T(), err)
+	require.Greater(s.T(), len(chunks), 2)
+
+	for i, chunk := range chunks {
+		assert.NotSame(s.T(), original, chunk)
+		if i > 0 {
+			assert.NotSame(s.T(), chunks[i-1], chunk)
+			assert.Equal(s.T(), chunks[i-1].End+1, chunk.Start, "parts must be contiguous")
+		}
+		assert.Equal(s.T(), i+1, chunk.Part)
+		assert.Equal(s.T(), len(chunks), chunk.Parts)
+		assert.Equal(s.T(), original.ID, chunk.ParentID)
+		assert.NotEqual(s.T(), original.ID, chunk.ID)
+		if i > 0 {
+			assert.NotEqual(s.T(), chunks[i-1].ID, chunk.ID)
+		}
+		assert.Equal(s.T(), ChunkTypeFunction, chunk.Type)
+		assert.Equal(s.T(), "Long", chunk.Name)
+		assert.Equal(s.T(), strings.Join(lines[chunk.Start-10:chunk.End-10+1], "\n"), chunk.Content)
+	}
+	assert.Equal(s.T(), 10, chunks[0].Start)
+	assert.Equal(s.T(), 31, chunks[len(chunks)-1].End)
+
+	// The original chunk is left untouched.
+	assert.Equal(s.T(), content, original.Content)
+	assert.Zero(s.T(), original.Part)
+
+	// Carried tokens are included in the line range of a part.
+	overlapped, err := splitChunk(original, s.tok, 4*stmtTokens, splitOptions{Overlap: stmtTokens + 1})
+	require.NoError(s.T(), err)
+	for i, chunk := range overlapped[1:] {
+		assert.Less(s.T(), chunk.Start, overlapped[i].End+1, "part %d", i+2)
+		partLines := strings.Split(chunk.Content, "\n")
+		require.Equal(s.T(), chunk.End-chunk.Start+1, len(partLines), "part %d", i+2)
+		assert.True(s.T(), strings.HasSuffix(lines[chunk.Start-10], partLines[0]), "part %d", i+2)
+		assert.Equal(s.T(), lines[chunk.Start-10+1:chunk.End-10+1], partLines[1:], "part %d", i+2)
+	}
+}
+
+func (s *GoSplitTestSuite) TestSplitChunkLongLine() {
+	lines := []string{
+		"\tmessage := fmt.Sprintf(\"%-10s|\t%5d  token   token\", name,\t\tcount) + strings.Repeat(\"token \", 3) // aligned",
+		// Every byte of a multi-byte character is a token of its own.
+		"\tmessage := \"日本語のテキストです。絵文字🎉🎉🎉も含みます\" // 日本語",
+	}
+
+	for _, line := range lines {
+		original := &Chunk{Content: line, Start: 7, End: 7}
+		for _, overlap := range []int{0, 2} {
+			s.T().Run(fmt.Sprintf("line=%.12q,overlap=%d", line, overlap), func(t *testing.T) {
+				chunks, err := splitChunk(original, s.tok, 6, splitOptions{Overlap: overlap})
+				require.NoError(t, err)
+				require.Greater(t, len(chunks), 2)
+
+				var joined strings.Builder
+				for i, chunk := range chunks {
+					assert.LessOrEqual(t, chunk.Size, 6)
+					assert.Equal(t, 7, chunk.Start)
+					assert.Equal(t, 7, chunk.End)
+					assert.NotEmpty(t, chunk.Content)
+					assert.True(t, utf8.ValidString(chunk.Content), "part %d: %q", i+1, chunk.Content)
+					content := chunk.Content
+					if i > 0 && overlap > 0 {
+						carried, err := tokenSuffix(s.tok, chunks[i-1].Content, overlap)
+						require.NoError(t, err)
+						require.True(t, strings.HasPrefix(content, carried))
+						content = strings.TrimPrefix(content, carried)
+					}
+					joined.WriteString(content)
+				}
+				// Concatenating the parts reproduces the line byte-for-byte.
+				assert.Equal(t, line, joined.String())
+			})
+		}
+	}
+}
+
+func (s *GoSplitTestSuite) TestSplitChunkSingleEncoding() {
+	words, err := NewTokenizer("words", "")
+	require.NoError(s.T(), err)
+
+	// The token "\n\ty" is counted for the line it ends on.
+	content := "x\n\ty z\n\nw"
+	splitter := &chunkSplitter{tok: words, lines: strings.Split(content, "\n")}
+	offsets, err := words.Offsets(content)
+	require.NoError(s.T(), err)
+	splitter.assignTokens(content, offsets)
+	assert.Equal(s.T(), [][]int{{1}, {2, 4}, nil, {1}}, splitter.lineOffsets)
+	assert.Equal(s.T(), 3, splitter.tokens(1, 4))
+
+	// Tokens made of newlines only are counted for the line ended by their first newline.
+	bytes, err := NewTokenizer("bytes", "")
+	require.NoError(s.T(), err)
+	content = "ab\n\ncd"
+	splitter = &chunkSplitter{tok: bytes, lines: strings.Split(content, "\n")}
+	offsets, err = bytes.Offsets(content)
+	require.NoError(s.T(), err)
+	splitter.assignTokens(content, offsets)
+	assert.Equal(s.T(), []int{1, 1, 0}, splitter.newlines)
+	assert.Equal(s.T(), 4, splitter.tokens(0, 2))
+
+	// "aaaa\nbbbb" takes 9 tokens, including the newline separating the lines.
+	for _, tc := range []struct {
+		maxTokens int
+		contents  []string
+	}{
+		{maxTokens: 8, contents: []string{"aaaa", "bbbb", "cccc"}},
+		{maxTokens: 9, contents: []string{"aaaa\nbbbb", "cccc"}},
+	} {
+		chunks, err := splitChunk(&Chunk{Content: "aaaa\nbbbb\ncccc"}, bytes, tc.maxTokens, splitOptions{})
+		require.NoError(s.T(), err)
+		require.Len(s.T(), chunks, len(tc.contents))
+		for i, chunk := range chunks {
+			assert.Equal(s.T(), tc.contents[i], chunk.Content)
+			assert.Equal(s.T(), len(chunk.Content), chunk.Size)
+		}
+	}
+}
+
+func (s *GoSplitTestSuite) TestSetChunkID() {
+	newChunk := func() *Chunk {
+		return &Chunk{
+			Content:  "func (l *List) Push(v int) {}",
+			Type:     ChunkTypeMethod,
+			Name:     "Push",
+			Receiver: "*List",
+			Path:     "list.go",
+			Start:    3,
+			End:      3,
+		}
+	}
+
+	original := newChunk()
+	setChunkID(original)
+	assert.Len(s.T(), original.ID, 32)
+	assert.Equal(s.T(), "5b506019e88ee395154dc3d2f0588c5ab8fcba2c9110938be47bafd5d5a58b7e", original.ContentHash)
+
+	// Unchanged code yields the same ID, even if it moved within the file.
+	same := newChunk()
+	same.Start, same.End = 10, 10
+	setChunkID(same)
+	assert.Equal(s.T(), original.ID, same.ID)
+	assert.Equal(s.T(), original.ContentHash, same.ContentHash)
+
+	for name, modify := range map[string]func(c *Chunk){
+		"content":  func(c *Chunk) { c.Content = "func (l *List) Push(v int) { l.n++ }" },
+		"path":     func(c *Chunk) { c.Path = "other.go" },
+		"receiver": func(c *Chunk) { c.Receiver = "*Stack" },
+		"part":     func(c *Chunk) { c.Part = 2 },
+	} {
+		changed := newChunk()
+		modify(changed)
+		setChunkID(changed)
+		assert.NotEqual(s.T(), original.ID, changed.ID, name)
+	}
+}
+
+func (s *GoSplitTestSuite) TestFuncSegments() {
+	content := `// Process handles items.
+func Process(items []string) int {
+	// count items
+	n := 0
+	for _, item := range items {
+		if item == "" {
+			continue
+		} else {
+			n++
+		}
+	}
+	switch n {
+	case 0:
+		return -1
+	}
+	return n
+}`
+
+	assert.Equal(s.T(), []segment{
+		{start: 0, end: 2, children: []segment{
+			{start: 0, end: 1},
+			{start: 1, end: 2},
+		}},
+		{start: 2, end: 4},
+		{start: 4, end: 11, children: []segment{
+			{start: 4, end: 5},
+			{start: 5, end: 10, children: []segment{
+				{start: 5, end: 6},
+				{start: 6, end: 7},
+				{start: 7, end: 9},
+				{start: 9, end: 10},
+			}},
+			{start: 10, end: 11},
+		}},
+		{start: 11, end: 15, children: []segment{
+			{start: 11, end: 12},
+			{start: 12, end: 14, children: []segment{
+				{start: 12, end: 13},
+				{start: 13, end: 14},
+			}},
+			{start: 14, end: 15},
+		}},
+		{start: 15, end: 16},
+		{start: 16, end: 17},
+	}, funcSegments(content))
+
+	assert.Nil(s.T(), funcSegments("type User struct {\n\tName string\n}"))
+	assert.Nil(s.T(), funcSegments("token token token"))
+}
+
+func TestGoSplitSuite(t *testing.T) {
+	suite.Run(t, new(GoSplitTestSuite))
+}
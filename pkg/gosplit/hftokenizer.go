@@ -0,0 +1,1131 @@
+package gosplit
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dlclark/regexp2"
+)
+
+// hfTokenizer counts tokens like a HuggingFace tokenizer defined by a tokenizer.json file.
+// It supports the BPE, WordPiece, WordLevel and Unigram models with the common normalizers
+// and pre-tokenizers. Unicode normalization forms (NFC, NFKC, ...) and precompiled
+// SentencePiece normalizers are not applied, which only affects text that is not already
+// normalized. Special tokens added by post-processors, such as [CLS] and [SEP], are not counted.
+type hfTokenizer struct {
+	name         string
+	addedTokens  map[byte][]string // Contents of the added tokens keyed by first byte, longest first
+	normalize    hfNormalizer
+	preTokenize  hfPreTokenizer
+	byteLevel    bool // Whether words are mapped to the byte-level alphabet before tokenization
+	model        hfModel
+	mu           sync.Mutex
+	cache        map[string][]int // Results of model.tokenize keyed by word
+	maxCacheSize int
+}
+
+// hfNormalizer transforms text before it is pre-tokenized.
+type hfNormalizer func(normalizedText) normalizedText
+
+// hfPreTokenizer splits text into the words that are tokenized by the model.
+type hfPreTokenizer func(words []normalizedText) ([]normalizedText, error)
+
+// hfModel tokenizes a pre-tokenized word. It returns the number of runes of the word covered
+// by each token; a token can cover no runes if a single rune is encoded as several tokens.
+type hfModel interface {
+	tokenize(word string) []int
+}
+
+// normalizedText is a text derived from a part of the original text by normalizers and
+// pre-tokenizers. Every byte of the text records the end offset in the original text of the
+// character it was derived from, so that tokens can be mapped back to the original text.
+type normalizedText struct {
+	text string
+	ends []int
+}
+
+func newNormalizedText(text string, offset int) normalizedText {
+	ends := make([]int, len(text))
+	for i := 0; i < len(text); {
+		_, size := utf8.DecodeRuneInString(text[i:])
+		for j := i; j < i+size; j++ {
+			ends[j] = offset + i + size
+		}
+		i += size
+	}
+	return normalizedText{text: text, ends: ends}
+}
+
+// normalizedBuilder builds a normalizedText piece by piece.
+type normalizedBuilder struct {
+	text strings.Builder
+	ends []int
+}
+
+// add appends s, derived from the original text up to the given end offset.
+func (b *normalizedBuilder) add(s string, end int) {
+	b.text.WriteString(s)
+	for range len(s) {
+		b.ends = append(b.ends, end)
+	}
+}
+
+func (b *normalizedBuilder) normalizedText() normalizedText {
+	return normalizedText{text: b.text.String(), ends: b.ends}
+}
+
+func (n normalizedText) slice(start, end int) normalizedText {
+	return normalizedText{text: n.text[start:end], ends: n.ends[start:end]}
+}
+
+// mapRunes replaces every rune of the text with the string returned by f.
+func (n normalizedText) mapRunes(f func(r rune) string) normalizedText {
+	var b normalizedBuilder
+	for i := 0; i < len(n.text); {
+		r, size := utf8.DecodeRuneInString(n.text[i:])
+		b.add(f(r), n.ends[i+size-1])
+		i += size
+	}
+	return b.normalizedText()
+}
+
+// replace replaces the given spans of the text with content.
+func (n normalizedText) replace(spans [][2]int, content string) normalizedText {
+	var b normalizedBuilder
+	last := 0
+	for _, span := range spans {
+		for i := last; i < span[0]; i++ {
+			b.add(n.text[i:i+1], n.ends[i])
+		}
+		if span[1] > span[0] {
+			b.add(content, n.ends[span[1]-1])
+		}
+		last = span[1]
+	}
+	for i := last; i < len(n.text); i++ {
+		b.add(n.text[i:i+1], n.ends[i])
+	}
+	return b.normalizedText()
+}
+
+// prepend returns the text prefixed with s. The prefix is treated as part of the first character.
+func (n normalizedText) prepend(s string) normalizedText {
+	end := 0
+	if len(n.ends) > 0 {
+		end = n.ends[0]
+	}
+	var b normalizedBuilder
+	b.add(s, end)
+	b.text.WriteString(n.text)
+	b.ends = append(b.ends, n.ends...)
+	return b.normalizedText()
+}
+
+// NewHFTokenizer returns a tokenizer that counts tokens with the HuggingFace tokenizer.json file.
+func NewHFTokenizer(file string) (Tokenizer, error) {
+	data, err := os.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, fmt.Errorf("error reading tokenizer file: %v", err)
+	}
+
+	var config struct {
+		AddedTokens []struct {
+			Content string `json:"content"`
+		} `json:"added_tokens"`
+		Normalizer   json.RawMessage `json:"normalizer"`
+		PreTokenizer json.RawMessage `json:"pre_tokenizer"`
+		Model        json.RawMessage `json:"model"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("error parsing tokenizer file: %v", err)
+	}
+
+	t := &hfTokenizer{
+		name:         hfTokenizerName(file),
+		addedTokens:  make(map[byte][]string),
+		cache:        make(map[string][]int),
+		maxCacheSize: 100000,
+	}
+	for _, added := range config.AddedTokens {
+		if added.Content != "" {
+			t.addedTokens[added.Content[0]] = append(t.addedTokens[added.Content[0]], added.Content)
+		}
+	}
+	for _, tokens := range t.addedTokens {
+		sort.SliceStable(tokens, func(i, j int) bool { return len(tokens[i]) > len(tokens[j]) })
+	}
+
+	if t.normalize, err = parseHFNormalizer(config.Normalizer); err != nil {
+		return nil, err
+	}
+	if t.preTokenize, err = t.parsePreTokenizer(config.PreTokenizer); err != nil {
+		return nil, err
+	}
+	if t.model, err = parseHFModel(config.Model); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// hfTokenizerName returns the name recorded in chunks for a tokenizer file. Tokenizer files are
+// usually named tokenizer.json, so the name of the directory containing the file, which is the
+// name of the model when it is downloaded from the HuggingFace Hub, is used instead.
+func hfTokenizerName(file string) string {
+	name := strings.TrimSuffix(filepath.Base(file), ".json")
+	if name == "tokenizer" {
+		if abs, err := filepath.Abs(file); err == nil {
+			name = filepath.Base(filepath.Dir(abs))
+		}
+	}
+	return "huggingface:" + name
+}
+
+func (t *hfTokenizer) Name() string { return t.name }
+
+func (t *hfTokenizer) Count(text string) (int, error) {
+	offsets, err := t.Offsets(text)
+	if err != nil {
+		return 0, err
+	}
+	return len(offsets), nil
+}
+
+func (t *hfTokenizer) Offsets(text string) ([]int, error) {
+	var offsets []int
+	start := 0
+	for start < len(text) {
+		// Added tokens are matched in the original text and encoded as single tokens.
+		end, added := t.nextAddedToken(text, start)
+		segment, err := t.segmentOffsets(newNormalizedText(text[start:end], start))
+		if err != nil {
+			return nil, err
+		}
+		offsets = append(offsets, segment...)
+		start = end
+		if added != "" {
+			start += len(added)
+			offsets = append(offsets, start)
+		}
+	}
+	if len(offsets) > 0 {
+		// Text removed by normalizers and pre-tokenizers, such as trailing spaces, belongs to the last token.
+		offsets[len(offsets)-1] = len(text)
+	}
+	// Tokens of a part of a character, such as byte fallback tokens, end with the character.
+	return runeBoundaries(text, offsets), nil
+}
+
+// nextAddedToken returns the offset of the first added token in text at or after start and
+// the token, or len(text) and "" if there is none.
+func (t *hfTokenizer) nextAddedToken(text string, start int) (int, string) {
+	if len(t.addedTokens) == 0 {
+		return len(text), ""
+	}
+	for i := start; i < len(text); i++ {
+		for _, added := range t.addedTokens[text[i]] {
+			if strings.HasPrefix(text[i:], added) {
+				return i, added
+			}
+		}
+	}
+	return len(text), ""
+}
+
+// segmentOffsets returns the end offsets of the tokens of a text without added tokens.
+func (t *hfTokenizer) segmentOffsets(text normalizedText) ([]int, error) {
+	if text.text == "" {
+		return nil, nil
+	}
+	if t.normalize != nil {
+		text = t.normalize(text)
+	}
+	words := []normalizedText{text}
+	if t.preTokenize != nil {
+		var err error
+		if words, err = t.preTokenize(words); err != nil {
+			return nil, err
+		}
+	}
+
+	var offsets []int
+	for _, word := range words {
+		// units holds the end offset in word.text of every rune passed to the model.
+		var model strings.Builder
+		var units []int
+		if t.byteLevel {
+			for i := 0; i < len(word.text); i++ {
+				model.WriteRune(byteLevelRune(word.text[i]))
+				units = append(units, i+1)
+			}
+		} else {
+			for i, r := range word.text {
+				model.WriteRune(r)
+				units = append(units, i+utf8.RuneLen(r))
+			}
+		}
+
+		unit := 0
+		for _, runes := range t.tokenize(model.String()) {
+			unit += runes
+			offsets = append(offsets, word.ends[units[max(unit, 1)-1]-1])
+		}
+	}
+	return offsets, nil
+}
+
+// tokenize tokenizes a word with the model, caching the result.
+func (t *hfTokenizer) tokenize(word string) []int {
+	t.mu.Lock()
+	tokens, ok := t.cache[word]
+	t.mu.Unlock()
+	if ok {
+		return tokens
+	}
+
+	tokens = t.model.tokenize(word)
+	t.mu.Lock()
+	if len(t.cache) < t.maxCacheSize {
+		t.cache[word] = tokens
+	}
+	t.mu.Unlock()
+	return tokens
+}
+
+// byteLevelRune maps a byte to the printable rune that represents it in byte-level vocabularies,
+// as done by GPT-2: printable Latin-1 characters represent themselves, and the other bytes are
+// mapped to the runes from U+0100 on.
+func byteLevelRune(b byte) rune {
+	switch {
+	case b >= '!' && b <= '~', b >= 0xA1 && b <= 0xAC, b >= 0xAE:
+		return rune(b)
+	case b <= ' ':
+		return 0x100 + rune(b)
+	case b <= 0xA0:
+		// 0x7F-0xA0 follow the 33 control characters and the space.
+		return 0x100 + 33 + rune(b-0x7F)
+	default:
+		// 0xAD follows 0x7F-0xA0.
+		return 0x100 + 33 + 34
+	}
+}
+
+// hfComponent holds the fields of the normalizers and pre-tokenizers of a tokenizer file.
+type hfComponent struct {
+	Type string `json:"type"`
+
+	Normalizers   []json.RawMessage `json:"normalizers"`
+	Pretokenizers []json.RawMessage `json:"pretokenizers"`
+
+	Pattern  *hfPattern `json:"pattern"`
+	Content  string     `json:"content"`
+	Prepend  string     `json:"prepend"`
+	Behavior string     `json:"behavior"`
+	Invert   bool       `json:"invert"`
+
+	StripLeft  bool `json:"strip_left"`
+	StripRight bool `json:"strip_right"`
+
+	CleanText          *bool `json:"clean_text"`
+	HandleChineseChars *bool `json:"handle_chinese_chars"`
+	StripAccents       *bool `json:"strip_accents"`
+	Lowercase          *bool `json:"lowercase"`
+
+	AddPrefixSpace   *bool  `json:"add_prefix_space"`
+	UseRegex         *bool  `json:"use_regex"`
+	Replacement      string `json:"replacement"`
+	PrependScheme    string `json:"prepend_scheme"`
+	Split            *bool  `json:"split"`
+	IndividualDigits bool   `json:"individual_digits"`
+	Delimiter        string `json:"delimiter"`
+}
+
+// hfPattern is the pattern of Split pre-tokenizers and Replace normalizers.
+type hfPattern struct {
+	String *string `json:"String"`
+	Regex  *string `json:"Regex"`
+}
+
+// spans returns a function finding the spans of the pattern in a text.
+func (p *hfPattern) spans() (func(string) ([][2]int, error), error) {
+	switch {
+	case p == nil:
+		return nil, fmt.Errorf("missing pattern")
+	case p.String != nil:
+		literal := *p.String
+		return func(s string) ([][2]int, error) { return literalSpans(s, literal), nil }, nil
+	case p.Regex != nil:
+		re, err := regexp2.Compile(*p.Regex, regexp2.Unicode)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", *p.Regex, err)
+		}
+		return func(s string) ([][2]int, error) { return regexpSpans(re, s) }, nil
+	}
+	return nil, fmt.Errorf("missing pattern")
+}
+
+func boolOr(b *bool, def bool) bool {
+	if b == nil {
+		return def
+	}
+	return *b
+}
+
+func parseHFComponent(data json.RawMessage) (*hfComponent, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+	var c hfComponent
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("error parsing tokenizer file: %v", err)
+	}
+	return &c, nil
+}
+
+// parseHFNormalizer returns the normalizer defined by a tokenizer file, or nil if there is none.
+func parseHFNormalizer(data json.RawMessage) (hfNormalizer, error) {
+	c, err := parseHFComponent(data)
+	if err != nil || c == nil {
+		return nil, err
+	}
+
+	switch c.Type {
+	case "Sequence":
+		var normalizers []hfNormalizer
+		for _, n := range c.Normalizers {
+			normalizer, err := parseHFNormalizer(n)
+			if err != nil {
+				return nil, err
+			}
+			if normalizer != nil {
+				normalizers = append(normalizers, normalizer)
+			}
+		}
+		return func(text normalizedText) normalizedText {
+			for _, normalizer := range normalizers {
+				text = normalizer(text)
+			}
+			return text
+		}, nil
+	case "NFC", "NFD", "NFKC", "NFKD", "Precompiled":
+		// Unicode normalization is not supported; source code is usually normalized already.
+		return nil, nil
+	case "Lowercase":
+		return func(text normalizedText) normalizedText {
+			return text.mapRunes(func(r rune) string { return string(unicode.ToLower(r)) })
+		}, nil
+	case "StripAccents":
+		return stripAccents, nil
+	case "Strip":
+		return func(text normalizedText) normalizedText {
+			start, end := 0, len(text.text)
+			if c.StripLeft {
+				start = end - len(strings.TrimLeftFunc(text.text, unicode.IsSpace))
+			}
+			if c.StripRight {
+				end = len(strings.TrimRightFunc(text.text[:end], unicode.IsSpace))
+			}
+			return text.slice(start, max(start, end))
+		}, nil
+	case "Replace":
+		find, err := c.Pattern.spans()
+		if err != nil {
+			return nil, fmt.Errorf("invalid Replace normalizer: %v", err)
+		}
+		return func(text normalizedText) normalizedText {
+			spans, err := find(text.text)
+			if err != nil {
+				return text
+			}
+			return text.replace(spans, c.Content)
+		}, nil
+	case "Prepend":
+		return func(text normalizedText) normalizedText {
+			if text.text == "" {
+				return text
+			}
+			return text.prepend(c.Prepend)
+		}, nil
+	case "BertNormalizer":
+		return bertNormalizer(c), nil
+	}
+	return nil, fmt.Errorf("unsupported normalizer %q", c.Type)
+}
+
+// stripAccents removes combining marks.
+func stripAccents(text normalizedText) normalizedText {
+	return text.mapRunes(func(r rune) string {
+		if unicode.Is(unicode.Mn, r) {
+			return ""
+		}
+		return string(r)
+	})
+}
+
+// bertNormalizer returns the normalizer of BERT models.
+func bertNormalizer(c *hfComponent) hfNormalizer {
+	cleanText := boolOr(c.CleanText, true)
+	chineseChars := boolOr(c.HandleChineseChars, true)
+	lowercase := boolOr(c.Lowercase, true)
+	accents := boolOr(c.StripAccents, lowercase)
+	return func(text normalizedText) normalizedText {
+		text = text.mapRunes(func(r rune) string {
+			switch {
+			case cleanText && (r == 0 || r == utf8.RuneError || isBertControl(r)):
+				return ""
+			case cleanText && unicode.IsSpace(r):
+				return " "
+			case chineseChars && isCJK(r):
+				return " " + string(r) + " "
+			case lowercase:
+				return string(unicode.ToLower(r))
+			}
+			return string(r)
+		})
+		if accents {
+			text = stripAccents(text)
+		}
+		return text
+	}
+}
+
+func isBertControl(r rune) bool {
+	if r == '\t' || r == '\n' || r == '\r' {
+		return false
+	}
+	return unicode.IsControl(r) || unicode.In(r, unicode.Cf, unicode.Co, unicode.Cs)
+}
+
+func isCJK(r rune) bool {
+	return (r >= 0x4E00 && r <= 0x9FFF) || (r >= 0x3400 && r <= 0x4DBF) ||
+		(r >= 0x20000 && r <= 0x2A6DF) || (r >= 0x2A700 && r <= 0x2B73F) ||
+		(r >= 0x2B740 && r <= 0x2B81F) || (r >= 0x2B820 && r <= 0x2CEAF) ||
+		(r >= 0xF900 && r <= 0xFAFF) || (r >= 0x2F800 && r <= 0x2FA1F)
+}
+
+func isBertPunctuation(r rune) bool {
+	if (r >= '!' && r <= '/') || (r >= ':' && r <= '@') || (r >= '[' && r <= '`') || (r >= '{' && r <= '~') {
+		return true
+	}
+	return unicode.IsPunct(r)
+}
+
+// gpt2Pattern is the pattern splitting text into words in byte-level pre-tokenizers.
+const gpt2Pattern = `'s|'t|'re|'ve|'m|'ll|'d| ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+|\s+(?!\S)|\s+`
+
+// wordPattern is the pattern of words kept by Whitespace pre-tokenizers.
+const wordPattern = `\w+|[^\w\s]+`
+
+// parsePreTokenizer returns the pre-tokenizer defined by a tokenizer file, or nil if there is none.
+// It enables byte-level tokenization if the file has a ByteLevel pre-tokenizer.
+func (t *hfTokenizer) parsePreTokenizer(data json.RawMessage) (hfPreTokenizer, error) {
+	c, err := parseHFComponent(data)
+	if err != nil || c == nil {
+		return nil, err
+	}
+
+	switch c.Type {
+	case "Sequence":
+		var preTokenizers []hfPreTokenizer
+		for _, p := range c.Pretokenizers {
+			preTokenizer, err := t.parsePreTokenizer(p)
+			if err != nil {
+				return nil, err
+			}
+			if preTokenizer != nil {
+				preTokenizers = append(preTokenizers, preTokenizer)
+			}
+		}
+		return func(words []normalizedText) ([]normalizedText, error) {
+			var err error
+			for _, preTokenizer := range preTokenizers {
+				if words, err = preTokenizer(words); err != nil {
+					return nil, err
+				}
+			}
+			return words, nil
+		}, nil
+	case "ByteLevel":
+		t.byteLevel = true
+		return byteLevelPreTokenizer(c), nil
+	case "Whitespace":
+		re := regexp2.MustCompile(wordPattern, regexp2.Unicode)
+		return splitPreTokenizer(func(s string) ([][2]int, error) { return regexpSpans(re, s) }, "Removed", true), nil
+	case "WhitespaceSplit":
+		return splitPreTokenizer(runSpans(unicode.IsSpace, true), "Removed", false), nil
+	case "BertPreTokenizer":
+		whitespace := splitPreTokenizer(runSpans(unicode.IsSpace, true), "Removed", false)
+		punctuation := splitPreTokenizer(runSpans(isBertPunctuation, false), "Isolated", false)
+		return func(words []normalizedText) ([]normalizedText, error) {
+			words, err := whitespace(words)
+			if err != nil {
+				return nil, err
+			}
+			return punctuation(words)
+		}, nil
+	case "Punctuation":
+		return splitPreTokenizer(runSpans(isBertPunctuation, false), behaviorOr(c.Behavior, "Isolated"), false), nil
+	case "Digits":
+		return splitPreTokenizer(runSpans(unicode.IsDigit, !c.IndividualDigits), "Isolated", false), nil
+	case "CharDelimiterSplit":
+		delimiter := c.Delimiter
+		return splitPreTokenizer(func(s string) ([][2]int, error) { return literalSpans(s, delimiter), nil }, "Removed", false), nil
+	case "Split":
+		find, err := c.Pattern.spans()
+		if err != nil {
+			return nil, fmt.Errorf("invalid Split pre-tokenizer: %v", err)
+		}
+		return splitPreTokenizer(find, behaviorOr(c.Behavior, "Isolated"), c.Invert), nil
+	case "Metaspace":
+		return metaspacePreTokenizer(c), nil
+	}
+	return nil, fmt.Errorf("unsupported pre-tokenizer %q", c.Type)
+}
+
+func behaviorOr(behavior, def string) string {
+	if behavior == "" {
+		return def
+	}
+	return behavior
+}
+
+// byteLevelPreTokenizer returns the pre-tokenizer of byte-level BPE models such as GPT-2.
+func byteLevelPreTokenizer(c *hfComponent) hfPreTokenizer {
+	addPrefixSpace := boolOr(c.AddPrefixSpace, true)
+	var split hfPreTokenizer
+	if boolOr(c.UseRegex, true) {
+		re := regexp2.MustCompile(gpt2Pattern, regexp2.Unicode)
+		split = splitPreTokenizer(func(s string) ([][2]int, error) { return regexpSpans(re, s) }, "Isolated", false)
+	}
+	return func(words []normalizedText) ([]normalizedText, error) {
+		if addPrefixSpace {
+			for i, word := range words {
+				if !strings.HasPrefix(word.text, " ") {
+					words[i] = word.prepend(" ")
+				}
+			}
+		}
+		if split == nil {
+			return words, nil
+		}
+		return split(words)
+	}
+}
+
+// metaspacePreTokenizer returns the pre-tokenizer of SentencePiece models, which replaces spaces
+// with a visible replacement character and splits the text before it.
+func metaspacePreTokenizer(c *hfComponent) hfPreTokenizer {
+	replacement := c.Replacement
+	if replacement == "" {
+		replacement = "▁"
+	}
+	scheme := c.PrependScheme
+	if scheme == "" {
+		scheme = "always"
+		if !boolOr(c.AddPrefixSpace, true) {
+			scheme = "never"
+		}
+	}
+	split := splitPreTokenizer(func(s string) ([][2]int, error) { return literalSpans(s, replacement), nil }, "MergedWithNext", false)
+	return func(words []normalizedText) ([]normalizedText, error) {
+		for i, word := range words {
+			word = word.replace(literalSpans(word.text, " "), replacement)
+			if (scheme == "always" || (scheme == "first" && i == 0)) && !strings.HasPrefix(word.text, replacement) {
+				word = word.prepend(replacement)
+			}
+			words[i] = word
+		}
+		if !boolOr(c.Split, true) {
+			return words, nil
+		}
+		return split(words)
+	}
+}
+
+// splitPreTokenizer returns a pre-tokenizer splitting every word at the spans found by find.
+// The behavior tells what to do with the spans: "Removed" drops them, "Isolated" makes them
+// separate words, "MergedWithPrevious" and "MergedWithNext" join them with the adjacent text,
+// and "Contiguous" makes adjacent spans a single word. If invert is true, the text between the
+// spans is treated as the spans and vice versa.
+func splitPreTokenizer(find func(string) ([][2]int, error), behavior string, invert bool) hfPreTokenizer {
+	return func(words []normalizedText) ([]normalizedText, error) {
+		var result []normalizedText
+		for _, word := range words {
+			spans, err := find(word.text)
+			if err != nil {
+				return nil, err
+			}
+			for _, span := range splitSpans(len(word.text), spans, behavior, invert) {
+				result = append(result, word.slice(span[0], span[1]))
+			}
+		}
+		return result, nil
+	}
+}
+
+// splitSpans returns the spans of the words of a text of length n split at the given matches.
+func splitSpans(n int, matches [][2]int, behavior string, invert bool) [][2]int {
+	type piece struct {
+		span  [2]int
+		match bool
+	}
+	var pieces []piece
+	last := 0
+	for _, m := range matches {
+		if m[0] > last {
+			pieces = append(pieces, piece{[2]int{last, m[0]}, invert})
+		}
+		if m[1] > m[0] {
+			pieces = append(pieces, piece{m, !invert})
+		}
+		last = m[1]
+	}
+	if last < n {
+		pieces = append(pieces, piece{[2]int{last, n}, invert})
+	}
+
+	var spans [][2]int
+	mergeNext := false
+	for i, p := range pieces {
+		switch {
+		case !p.match:
+		case behavior == "Removed":
+			continue
+		case behavior == "MergedWithPrevious" && len(spans) > 0 && !pieces[i-1].match:
+			spans[len(spans)-1][1] = p.span[1]
+			continue
+		case behavior == "Contiguous" && i > 0 && pieces[i-1].match:
+			spans[len(spans)-1][1] = p.span[1]
+			continue
+		}
+		if mergeNext {
+			spans[len(spans)-1][1] = p.span[1]
+		} else {
+			spans = append(spans, p.span)
+		}
+		mergeNext = p.match && behavior == "MergedWithNext"
+	}
+	return spans
+}
+
+// literalSpans returns the spans of the non-overlapping occurrences of literal in s.
+func literalSpans(s, literal string) [][2]int {
+	if literal == "" {
+		return nil
+	}
+	var spans [][2]int
+	for start := 0; ; {
+		i := strings.Index(s[start:], literal)
+		if i < 0 {
+			return spans
+		}
+		start += i
+		spans = append(spans, [2]int{start, start + len(literal)})
+		start += len(literal)
+	}
+}
+
+// regexpSpans returns the spans of the matches of re in s.
+func regexpSpans(re *regexp2.Regexp, s string) ([][2]int, error) {
+	// The regexp2 package reports rune indexes, which are converted to byte offsets.
+	offsets := make([]int, 0, len(s)+1)
+	for i := range s {
+		offsets = append(offsets, i)
+	}
+	offsets = append(offsets, len(s))
+
+	var spans [][2]int
+	m, err := re.FindStringMatch(s)
+	for ; m != nil && err == nil; m, err = re.FindNextMatch(m) {
+		spans = append(spans, [2]int{offsets[m.Index], offsets[m.Index+m.Length]})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error matching pattern: %v", err)
+	}
+	return spans, nil
+}
+
+// runSpans returns a function finding the runes satisfying pred, as runs of adjacent runes if
+// contiguous is true and one by one otherwise.
+func runSpans(pred func(rune) bool, contiguous bool) func(string) ([][2]int, error) {
+	return func(s string) ([][2]int, error) {
+		var spans [][2]int
+		for i, r := range s {
+			if !pred(r) {
+				continue
+			}
+			end := i + utf8.RuneLen(r)
+			if contiguous && len(spans) > 0 && spans[len(spans)-1][1] == i {
+				spans[len(spans)-1][1] = end
+			} else {
+				spans = append(spans, [2]int{i, end})
+			}
+		}
+		return spans, nil
+	}
+}
+
+// hfModelConfig holds the fields of the models of a tokenizer file.
+type hfModelConfig struct {
+	Type                    string          `json:"type"`
+	Vocab                   json.RawMessage `json:"vocab"`
+	Merges                  json.RawMessage `json:"merges"`
+	UnkToken                *string         `json:"unk_token"`
+	ContinuingSubwordPrefix *string         `json:"continuing_subword_prefix"`
+	EndOfWordSuffix         *string         `json:"end_of_word_suffix"`
+	ByteFallback            bool            `json:"byte_fallback"`
+	FuseUnk                 bool            `json:"fuse_unk"`
+	IgnoreMerges            bool            `json:"ignore_merges"`
+	MaxInputCharsPerWord    int             `json:"max_input_chars_per_word"`
+}
+
+func parseHFModel(data json.RawMessage) (hfModel, error) {
+	var c hfModelConfig
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("error parsing tokenizer model: %v", err)
+	}
+
+	switch c.Type {
+	case "BPE":
+		return newBPEModel(&c)
+	case "WordPiece":
+		return newWordPieceModel(&c)
+	case "WordLevel":
+		return wordLevelModel{}, nil
+	case "Unigram":
+		return newUnigramModel(&c)
+	}
+	return nil, fmt.Errorf("unsupported tokenizer model %q", c.Type)
+}
+
+// wordLevelModel encodes every word as a single token, which is the unknown token if the
+// word is not in the vocabulary.
+type wordLevelModel struct{}
+
+func (wordLevelModel) tokenize(word string) []int {
+	return []int{utf8.RuneCountInString(word)}
+}
+
+// wordPieceModel encodes words greedily with the longest tokens of the vocabulary, as BERT does.
+type wordPieceModel struct {
+	vocab    map[string]int
+	prefix   string
+	maxChars int
+}
+
+func newWordPieceModel(c *hfModelConfig) (*wordPieceModel, error) {
+	m := &wordPieceModel{prefix: "##", maxChars: 100}
+	if err := json.Unmarshal(c.Vocab, &m.vocab); err != nil {
+		return nil, fmt.Errorf("error parsing WordPiece vocabulary: %v", err)
+	}
+	if c.ContinuingSubwordPrefix != nil {
+		m.prefix = *c.ContinuingSubwordPrefix
+	}
+	if c.MaxInputCharsPerWord > 0 {
+		m.maxChars = c.MaxInputCharsPerWord
+	}
+	return m, nil
+}
+
+func (m *wordPieceModel) tokenize(word string) []int {
+	offsets := runeOffsets(word)
+	n := len(offsets) - 1
+	if n > m.maxChars {
+		return []int{n}
+	}
+
+	var tokens []int
+	for start := 0; start < n; {
+		end := n
+		for ; end > start; end-- {
+			sub := word[offsets[start]:offsets[end]]
+			if start > 0 {
+				sub = m.prefix + sub
+			}
+			if _, ok := m.vocab[sub]; ok {
+				break
+			}
+		}
+		if end == start {
+			// The word cannot be encoded and becomes the unknown token.
+			return []int{n}
+		}
+		tokens = append(tokens, end-start)
+		start = end
+	}
+	return tokens
+}
+
+// runeOffsets returns the byte offsets of the runes of s, followed by len(s).
+func runeOffsets(s string) []int {
+	offsets := make([]int, 0, len(s)+1)
+	for i := range s {
+		offsets = append(offsets, i)
+	}
+	return append(offsets, len(s))
+}
+
+// bpeModel encodes words by applying the merges of a byte-pair encoding in order of priority.
+type bpeModel struct {
+	vocab        map[string]int
+	ranks        map[[2]string]int
+	unk          string
+	prefix       string
+	suffix       string
+	byteFallback bool
+	fuseUnk      bool
+	ignoreMerges bool
+}
+
+func newBPEModel(c *hfModelConfig) (*bpeModel, error) {
+	m := &bpeModel{
+		ranks:        make(map[[2]string]int),
+		byteFallback: c.ByteFallback,
+		fuseUnk:      c.FuseUnk,
+		ignoreMerges: c.IgnoreMerges,
+	}
+	if err := json.Unmarshal(c.Vocab, &m.vocab); err != nil {
+		return nil, fmt.Errorf("error parsing BPE vocabulary: %v", err)
+	}
+	if c.UnkToken != nil {
+		m.unk = *c.UnkToken
+	}
+	if c.ContinuingSubwordPrefix != nil {
+		m.prefix = *c.ContinuingSubwordPrefix
+	}
+	if c.EndOfWordSuffix != nil {
+		m.suffix = *c.EndOfWordSuffix
+	}
+
+	// Merges are either "a b" strings or ["a", "b"] pairs.
+	var merges []json.RawMessage
+	if len(c.Merges) > 0 {
+		if err := json.Unmarshal(c.Merges, &merges); err != nil {
+			return nil, fmt.Errorf("error parsing BPE merges: %v", err)
+		}
+	}
+	for rank, merge := range merges {
+		var pair [2]string
+		var s string
+		if err := json.Unmarshal(merge, &s); err == nil {
+			a, b, ok := strings.Cut(s, " ")
+			if !ok {
+				return nil, fmt.Errorf("invalid BPE merge %q", s)
+			}
+			pair = [2]string{a, b}
+		} else if err := json.Unmarshal(merge, &pair); err != nil {
+			return nil, fmt.Errorf("invalid BPE merge %s", merge)
+		}
+		if _, ok := m.ranks[pair]; !ok {
+			m.ranks[pair] = rank
+		}
+	}
+	return m, nil
+}
+
+// bpeSymbol is a token of a word being encoded.
+type bpeSymbol struct {
+	text      string
+	runes     int  // The number of runes of the word covered by the symbol
+	mergeable bool // Whether the symbol is in the vocabulary and can be merged
+	unknown   bool // Whether the symbol is the unknown token
+}
+
+func (m *bpeModel) tokenize(word string) []int {
+	n := utf8.RuneCountInString(word)
+	if m.ignoreMerges {
+		if _, ok := m.vocab[word]; ok {
+			return []int{n}
+		}
+	}
+
+	var symbols []bpeSymbol
+	i := 0
+	for _, r := range word {
+		text := string(r)
+		if i > 0 {
+			text = m.prefix + text
+		}
+		if i == n-1 {
+			text += m.suffix
+		}
+		i++
+		symbols = append(symbols, m.initialSymbols(text)...)
+	}
+
+	for {
+		best, bestRank := -1, math.MaxInt
+		for j := 0; j+1 < len(symbols); j++ {
+			if !symbols[j].mergeable || !symbols[j+1].mergeable {
+				continue
+			}
+			if rank, ok := m.ranks[[2]string{symbols[j].text, symbols[j+1].text}]; ok && rank < bestRank {
+				best, bestRank = j, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		merged := bpeSymbol{
+			text:      symbols[best].text + strings.TrimPrefix(symbols[best+1].text, m.prefix),
+			runes:     symbols[best].runes + symbols[best+1].runes,
+			mergeable: true,
+		}
+		symbols = append(symbols[:best+1], symbols[best+2:]...)
+		symbols[best] = merged
+	}
+
+	tokens := make([]int, 0, len(symbols))
+	pending := 0
+	fused := false // Whether the last token appended is an unknown token
+	for _, s := range symbols {
+		switch {
+		case s.text == "":
+			// An unknown rune without an unknown token is dropped.
+			pending += s.runes
+			continue
+		case s.unknown && fused:
+			// Adjacent unknown runes are fused into a single unknown token.
+			tokens[len(tokens)-1] += pending + s.runes
+			pending = 0
+			continue
+		}
+		tokens = append(tokens, pending+s.runes)
+		pending = 0
+		fused = s.unknown && m.fuseUnk
+	}
+	if pending > 0 && len(tokens) > 0 {
+		tokens[len(tokens)-1] += pending
+	}
+	return tokens
+}
+
+// initialSymbols returns the symbols of a single rune (with its prefix or suffix, if any)
+// before merges are applied. Runes that are not in the vocabulary are encoded as byte tokens
+// if the model has byte fallback, and as the unknown token otherwise.
+func (m *bpeModel) initialSymbols(text string) []bpeSymbol {
+	if _, ok := m.vocab[text]; ok {
+		return []bpeSymbol{{text: text, runes: 1, mergeable: true}}
+	}
+	if m.byteFallback {
+		raw := strings.TrimSuffix(strings.TrimPrefix(text, m.prefix), m.suffix)
+		symbols := make([]bpeSymbol, len(raw))
+		for i := 0; i < len(raw); i++ {
+			symbols[i] = bpeSymbol{text: fmt.Sprintf("<0x%02X>", raw[i])}
+			if _, ok := m.vocab[symbols[i].text]; !ok {
+				symbols = nil
+				break
+			}
+		}
+		if symbols != nil {
+			symbols[0].runes = 1
+			return symbols
+		}
+	}
+	return []bpeSymbol{{text: m.unk, runes: 1, unknown: true}}
+}
+
+// unigramModel encodes words with the segmentation of the highest probability, as SentencePiece does.
+type unigramModel struct {
+	scores       map[string]float64
+	maxRunes     int
+	unkScore     float64
+	byteFallback bool
+}
+
+func newUnigramModel(c *hfModelConfig) (*unigramModel, error) {
+	var vocab []struct {
+		Piece string
+		Score float64
+	}
+	var entries [][]json.RawMessage
+	if err := json.Unmarshal(c.Vocab, &entries); err != nil {
+		return nil, fmt.Errorf("error parsing Unigram vocabulary: %v", err)
+	}
+	for _, entry := range entries {
+		if len(entry) != 2 {
+			return nil, fmt.Errorf("invalid Unigram vocabulary entry")
+		}
+		var v struct {
+			Piece string
+			Score float64
+		}
+		if err := json.Unmarshal(entry[0], &v.Piece); err != nil {
+			return nil, fmt.Errorf("invalid Unigram vocabulary entry: %v", err)
+		}
+		if err := json.Unmarshal(entry[1], &v.Score); err != nil {
+			return nil, fmt.Errorf("invalid Unigram vocabulary entry: %v", err)
+		}
+		vocab = append(vocab, v)
+	}
+
+	m := &unigramModel{scores: make(map[string]float64, len(vocab)), byteFallback: c.ByteFallback}
+	minScore := 0.0
+	for _, v := range vocab {
+		m.scores[v.Piece] = v.Score
+		m.maxRunes = max(m.maxRunes, utf8.RuneCountInString(v.Piece))
+		minScore = min(minScore, v.Score)
+	}
+	// Unknown runes are penalized in the same way as by the tokenizers library.
+	m.unkScore = minScore - 10
+	return m, nil
+}
+
+func (m *unigramModel) tokenize(word string) []int {
+	offsets := runeOffsets(word)
+	n := len(offsets) - 1
+
+	// best[i] is the score of the best segmentation of the first i runes, whose last token
+	// starts at rune start[i]. Tokens of unknown runes are marked in unknown[i].
+	best := make([]float64, n+1)
+	start := make([]int, n+1)
+	unknown := make([]bool, n+1)
+	for i := 1; i <= n; i++ {
+		best[i] = math.Inf(-1)
+	}
+	for i := 0; i < n; i++ {
+		if math.IsInf(best[i], -1) {
+			continue
+		}
+		for j := i + 1; j <= min(n, i+m.maxRunes); j++ {
+			score, ok := m.scores[word[offsets[i]:offsets[j]]]
+			if ok && best[i]+score > best[j] {
+				best[j], start[j], unknown[j] = best[i]+score, i, false
+			}
+		}
+		if _, ok := m.scores[word[offsets[i]:offsets[i+1]]]; !ok && best[i]+m.unkScore > best[i+1] {
+			best[i+1], start[i+1], unknown[i+1] = best[i]+m.unkScore, i, true
+		}
+	}
+
+	var reversed []int
+	fused := false // Whether the last token appended is an unknown token
+	for i := n; i > 0; i = start[i] {
+		switch {
+		case unknown[i] && m.byteFallback:
+			// The unknown rune is encoded as one token per byte.
+			for range offsets[i] - offsets[start[i]] - 1 {
+				reversed = append(reversed, 0)
+			}
+		case unknown[i] && fused:
+			// Adjacent unknown runes are fused into a single unknown token.
+			reversed[len(reversed)-1] += i - start[i]
+			continue
+		}
+		reversed = append(reversed, i-start[i])
+		fused = unknown[i] && !m.byteFallback
+	}
+
+	tokens := make([]int, len(reversed))
+	for i, t := range reversed {
+		tokens[len(reversed)-1-i] = t
+	}
+	return tokens
+}
@@ -0,0 +1,44 @@
+package gosplit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// setChunkID sets the content hash of the chunk and its ID.
+// The ID is derived from the path, the qualified symbol name, the type, the part number,
+// the content hash and the file context, if any, of the chunk, so that it stays the same as long
+// as the code does not change.
+func setChunkID(c *Chunk) {
+	sum := sha256.Sum256([]byte(c.Content))
+	c.ContentHash = hex.EncodeToString(sum[:])
+
+	fields := []string{
+		c.Path,
+		qualifiedName(c),
+		string(c.Type),
+		strconv.Itoa(c.Part),
+		c.ContentHash,
+	}
+	if c.Context != "" {
+		fields = append(fields, c.Context)
+	}
+	key := strings.Join(fields, "\x00")
+	id := sha256.Sum256([]byte(key))
+	c.ID = hex.EncodeToString(id[:16])
+}
+
+// qualifiedName returns the name of the symbol declared by the chunk qualified by its receiver
+// and import path, if any, e.g. "example.com/m.*List[T].Push".
+func qualifiedName(c *Chunk) string {
+	name := c.Name
+	if c.Receiver != "" {
+		name = c.Receiver + "." + name
+	}
+	if c.ImportPath != "" {
+		name = c.ImportPath + "." + name
+	}
+	return name
+}
@@ -0,0 +1,95 @@
+package gosplit
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// File is a Go source file to be split into chunks.
+type File struct {
+	Path       string // The path of the file on disk
+	ImportPath string // The import path of the package containing the file, if known
+}
+
+// BuildContext selects the build configuration used to resolve packages.
+type BuildContext struct {
+	Dir    string   // The directory in which the go command is run, typically the module root
+	Tags   []string // Additional build tags
+	GOOS   string   // The target operating system (default: the host's)
+	GOARCH string   // The target architecture (default: the host's)
+	Tests  bool     // Whether to include test files
+}
+
+// LoadPackageFiles resolves the given package patterns with go/packages and returns the
+// Go files that are part of the build for the given build context, sorted by path.
+// Files excluded by build constraints or GOOS/GOARCH file name suffixes are not returned.
+func LoadPackageFiles(patterns []string, bc BuildContext) ([]File, error) {
+	pkgs, err := packages.Load(packagesConfig(bc, packages.NeedName|packages.NeedFiles), patterns...)
+	if err != nil {
+		return nil, fmt.Errorf("error loading packages: %v", err)
+	}
+
+	// Visit plain packages before their test variants so that files shared by
+	// both are attributed to the package under test.
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].ID < pkgs[j].ID })
+
+	seen := make(map[string]bool)
+	var files []File
+	for _, pkg := range pkgs {
+		if len(pkg.Errors) > 0 {
+			return nil, fmt.Errorf("error loading package %s: %v", pkg.ID, pkg.Errors[0])
+		}
+		// Skip the synthesized main packages of test binaries.
+		if strings.HasSuffix(pkg.ID, ".test") {
+			continue
+		}
+		for _, path := range pkg.GoFiles {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			files = append(files, File{Path: path, ImportPath: pkg.PkgPath})
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// dirImportPath returns the import path of the package in dir as resolved by the go command for
+// the build context, or "" if it cannot be resolved, e.g. because dir is not within a module.
+func dirImportPath(dir string, bc BuildContext) string {
+	cfg := packagesConfig(bc, packages.NeedName)
+	cfg.Dir = dir
+	cfg.Tests = false
+	pkgs, err := packages.Load(cfg, ".")
+	if err != nil || len(pkgs) != 1 || len(pkgs[0].Errors) > 0 {
+		return ""
+	}
+	return pkgs[0].PkgPath
+}
+
+// packagesConfig returns the go/packages configuration loading the given information for the
+// build context.
+func packagesConfig(bc BuildContext, mode packages.LoadMode) *packages.Config {
+	cfg := &packages.Config{
+		Mode:  mode,
+		Dir:   bc.Dir,
+		Tests: bc.Tests,
+		Env:   os.Environ(),
+	}
+	if len(bc.Tags) > 0 {
+		cfg.BuildFlags = []string{"-tags=" + strings.Join(bc.Tags, ",")}
+	}
+	if bc.GOOS != "" {
+		cfg.Env = append(cfg.Env, "GOOS="+bc.GOOS)
+	}
+	if bc.GOARCH != "" {
+		cfg.Env = append(cfg.Env, "GOARCH="+bc.GOARCH)
+	}
+	return cfg
+}
@@ -0,0 +1,491 @@
+package gosplit
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+// segment is a range of whole lines of a chunk's content that is syntactically meaningful,
+// such as a statement of a function body. Content is only cut at segment boundaries,
+// preferring coarser segments over the finer-grained segments they are made of.
+type segment struct {
+	start    int       // Index of the first line of the segment
+	end      int       // Index of the line after the last line of the segment
+	children []segment // Finer-grained segments covering the same lines, if any
+}
+
+// partMarkerFormat is the comment inserted after the repeated declaration header of split parts.
+const partMarkerFormat = "\t// ... (part %d of %d)"
+
+// splitOptions controls how chunks exceeding the maximum number of tokens are split.
+type splitOptions struct {
+	// RepeatHeader makes every part of a split function or method after the first start with
+	// the declaration header (doc comment and signature) followed by a part marker comment.
+	RepeatHeader bool
+	// Overlap is the number of trailing tokens of every part that are repeated at the start
+	// of the next part, so that the context around cut points is not lost.
+	Overlap int
+}
+
+// splitChunk splits a chunk whose content exceeds maxTokens into parts of at most maxTokens tokens,
+// as counted by tok.
+// Functions and methods are cut at statement boundaries, descending into nested blocks such as
+// if, for and switch statements only when a statement does not fit into a part on its own.
+// Other content, and statements that cannot be split further, are cut at line boundaries,
+// and lines that are too long on their own are cut at token boundaries.
+// Each part is a new chunk with its own line range and size, numbered from 1 with the total number
+// of parts, and refers to the original chunk by its parent ID.
+// The content is encoded only once, and the cut points are found from the offsets of its tokens.
+func splitChunk(chunk *Chunk, tok Tokenizer, maxTokens int, opts splitOptions) ([]*Chunk, error) {
+	// If maxTokens is 0 or negative, return the original chunk
+	if maxTokens <= 0 {
+		return []*Chunk{chunk}, nil
+	}
+
+	// Encode the content once; the splitter works from the offsets of its tokens
+	offsets, err := tok.Offsets(chunk.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	// If content is within limit, return as is
+	if len(offsets) <= maxTokens {
+		return []*Chunk{chunk}, nil
+	}
+
+	if opts.Overlap < 0 || opts.Overlap >= maxTokens {
+		return nil, fmt.Errorf("chunk overlap must be between 0 and %d: %d", maxTokens-1, opts.Overlap)
+	}
+
+	// The parts are packed by the tokens of the whole content, which can differ from the tokens of
+	// a part on its own where tokens merge differently across a cut point or the joined overlap
+	// and header. Parts are packed into a smaller budget until every part fits.
+	for budget := maxTokens; budget > opts.Overlap; {
+		chunks, err := packChunk(chunk, tok, offsets, budget, opts)
+		if err != nil {
+			return nil, err
+		}
+		excess := 0
+		for _, c := range chunks {
+			excess = max(excess, c.Size-maxTokens)
+		}
+		if excess == 0 {
+			return chunks, nil
+		}
+		budget -= excess
+	}
+	return nil, fmt.Errorf("chunk cannot be split into parts of at most %d tokens", maxTokens)
+}
+
+// packChunk splits a chunk into parts whose content has at most maxTokens of the given tokens of
+// the chunk's content, and sets the size of every part to the number of tokens of its content.
+func packChunk(chunk *Chunk, tok Tokenizer, offsets []int, maxTokens int, opts splitOptions) ([]*Chunk, error) {
+	// Every part after the first starts with the overlap and the newline separating it from the part.
+	reserved := opts.Overlap
+	if opts.Overlap > 0 {
+		sepTokens, err := tok.Count("\n")
+		if err != nil {
+			return nil, err
+		}
+		reserved += sepTokens
+	}
+
+	var err error
+	lines := strings.Split(chunk.Content, "\n")
+	s := &chunkSplitter{tok: tok, lines: lines, maxTokens: maxTokens, reserved: reserved}
+	s.assignTokens(chunk.Content, offsets)
+	segments := funcSegments(chunk.Content)
+
+	var header string
+	if opts.RepeatHeader && segments != nil {
+		header, err = s.header(segments[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if segments != nil {
+		for _, seg := range segments {
+			s.addSegment(seg)
+		}
+	} else {
+		s.addLines(0, len(lines))
+	}
+	s.flush()
+
+	chunks := make([]*Chunk, 0, len(s.parts))
+	for i, p := range s.parts {
+		part := *chunk
+		part.Content = p.content
+		part.Start = chunk.Start + p.start
+		part.End = chunk.Start + p.end
+		part.Part = i + 1
+		part.Parts = len(s.parts)
+		part.ParentID = chunk.ID
+		if i > 0 {
+			// The callers of a split function are recorded once.
+			part.CalledBy = nil
+		}
+		if opts.Overlap > 0 && i > 0 {
+			overlap, err := tokenSuffix(tok, s.parts[i-1].content, opts.Overlap)
+			if err != nil {
+				return nil, err
+			}
+			part.Content = overlap + p.sep + part.Content
+			part.Start -= strings.Count(overlap+p.sep, "\n")
+		}
+		if header != "" && i > 0 {
+			marker := fmt.Sprintf(partMarkerFormat, i+1, len(s.parts))
+			part.Content = header + "\n" + marker + "\n" + part.Content
+		}
+		if part.Size, err = tok.Count(part.Content); err != nil {
+			return nil, err
+		}
+		setChunkID(&part)
+		chunks = append(chunks, &part)
+	}
+	return chunks, nil
+}
+
+// splitPart is a part of a chunk's content produced by chunkSplitter.
+type splitPart struct {
+	content string // The content of the part
+	sep     string // The text between the part and the preceding part in the original content
+	start   int    // Index of the first line of the part
+	end     int    // Index of the last line of the part
+}
+
+// chunkSplitter accumulates the lines of a chunk's content into parts of at most maxTokens tokens.
+type chunkSplitter struct {
+	tok       Tokenizer
+	lines     []string
+	maxTokens int
+
+	// The tokens of the content are assigned to lines: lineOffsets[i] holds the end offsets,
+	// relative to the start of the i-th line, of the tokens assigned to it, newlines[i] the number
+	// of tokens made of newlines only that end it, and tokensBefore[i] is the number of tokens
+	// assigned to the lines before it.
+	lineOffsets  [][]int
+	newlines     []int
+	tokensBefore []int
+
+	parts []splitPart // The completed parts
+
+	// The part being built consists of the lines in [currentStart, currentEnd).
+	currentStart  int
+	currentEnd    int
+	currentTokens int
+
+	// reserved is the number of tokens reserved in every part after the first,
+	// e.g. for overlapping tokens and a repeated declaration header.
+	reserved int
+}
+
+// assignTokens assigns the tokens of content, given by their end offsets, to its lines.
+// A token is assigned to the line of its last character other than a newline, so that a token
+// made of a newline and the indentation of the next line counts for the next line. A token made
+// of newlines only counts for the line ended by its first newline, so that the tokens of a range
+// of lines include the newlines between them. A token ending within the character ended by the
+// previous token is assigned in the same way as the previous token.
+func (s *chunkSplitter) assignTokens(content string, offsets []int) {
+	s.lineOffsets = make([][]int, len(s.lines))
+	s.newlines = make([]int, len(s.lines))
+	s.tokensBefore = make([]int, len(s.lines)+1)
+
+	line, lineStart := 0, 0
+	start := 0
+	for i, end := range offsets {
+		if i > 0 && end == start {
+			if n := len(s.lineOffsets[line]); n > 0 {
+				s.lineOffsets[line] = append(s.lineOffsets[line], s.lineOffsets[line][n-1])
+			} else {
+				s.newlines[line]++
+			}
+			continue
+		}
+		last := end - 1
+		for last >= start && content[last] == '\n' {
+			last--
+		}
+		if last < start {
+			for lineStart+len(s.lines[line]) < start {
+				lineStart += len(s.lines[line]) + 1
+				line++
+			}
+			s.newlines[line]++
+		} else {
+			for lineStart+len(s.lines[line]) <= last {
+				lineStart += len(s.lines[line]) + 1
+				line++
+			}
+			lineEnd := min(end, lineStart+len(s.lines[line]))
+			s.lineOffsets[line] = append(s.lineOffsets[line], max(lineEnd-lineStart, 0))
+		}
+		start = end
+	}
+
+	for i, lineOffsets := range s.lineOffsets {
+		s.tokensBefore[i+1] = s.tokensBefore[i] + len(lineOffsets) + s.newlines[i]
+	}
+}
+
+// tokens returns the number of tokens assigned to the lines in [start, end), including the
+// newlines ending them.
+func (s *chunkSplitter) tokens(start, end int) int {
+	return s.tokensBefore[end] - s.tokensBefore[start]
+}
+
+// limit returns the maximum number of tokens of the content of the part being built.
+func (s *chunkSplitter) limit() int {
+	if len(s.parts) == 0 {
+		return s.maxTokens
+	}
+	return s.maxTokens - s.reserved
+}
+
+// header returns the declaration header to be repeated in every part after the first,
+// given the header segment of a function, and reserves room for it and the part marker.
+// The doc comment is left out if the full header would take more than half of a part,
+// and no header is repeated if even the signature alone would.
+func (s *chunkSplitter) header(seg segment) (string, error) {
+	// The header is followed by the marker on a line of its own.
+	markerTokens, err := s.tok.Count(fmt.Sprintf(partMarkerFormat, 999, 999) + "\n")
+	if err != nil {
+		return "", err
+	}
+
+	candidates := []segment{seg}
+	if len(seg.children) > 0 {
+		// The last child of a function header is its signature without the doc comment.
+		candidates = append(candidates, seg.children[len(seg.children)-1])
+	}
+	for _, candidate := range candidates {
+		header := strings.Join(s.lines[candidate.start:candidate.end], "\n")
+		headerTokens := s.tokens(candidate.start, candidate.end)
+		if (headerTokens+markerTokens)*2 <= s.maxTokens-s.reserved {
+			s.reserved += headerTokens + markerTokens
+			return header, nil
+		}
+	}
+	return "", nil
+}
+
+// flush completes the part being built, if any. Parts consisting of blank lines only are dropped.
+func (s *chunkSplitter) flush() {
+	content := strings.Join(s.lines[s.currentStart:s.currentEnd], "\n")
+	if strings.TrimSpace(content) != "" {
+		s.parts = append(s.parts, splitPart{
+			content: content,
+			sep:     "\n",
+			start:   s.currentStart,
+			end:     s.currentEnd - 1,
+		})
+	}
+	s.currentStart = s.currentEnd
+	s.currentTokens = 0
+}
+
+// add appends the lines in [start, end) to the part being built, starting a new part if they do
+// not fit. It reports false, adding nothing, if the lines do not fit into a new part either.
+func (s *chunkSplitter) add(start, end, tokens int) bool {
+	// The newline ending the last line is not part of the content.
+	trailing := s.newlines[end-1]
+	if s.currentTokens+tokens-trailing > s.limit() {
+		// A new part is never the first one, as the part being built is not empty.
+		if s.currentStart == s.currentEnd || tokens-trailing > s.maxTokens-s.reserved {
+			return false
+		}
+		s.flush()
+	}
+	if s.currentStart == s.currentEnd {
+		s.currentStart = start
+	}
+	s.currentEnd = end
+	s.currentTokens += tokens
+	return true
+}
+
+// addSegment adds the lines of seg, splitting it into its children if it does not fit into a part.
+func (s *chunkSplitter) addSegment(seg segment) {
+	if s.add(seg.start, seg.end, s.tokens(seg.start, seg.end)) {
+		return
+	}
+
+	if len(seg.children) == 0 {
+		s.addLines(seg.start, seg.end)
+		return
+	}
+	for _, child := range seg.children {
+		s.addSegment(child)
+	}
+}
+
+// addLines adds the lines in [start, end) one by one, splitting lines that do not fit into a part.
+func (s *chunkSplitter) addLines(start, end int) {
+	for i := start; i < end; i++ {
+		// If a single line exceeds the limit, we need to split it
+		if !s.add(i, i+1, s.tokens(i, i+1)) {
+			s.flush()
+			s.addLongLine(i)
+			s.currentStart, s.currentEnd = i+1, i+1
+		}
+	}
+}
+
+// addLongLine splits the i-th line, which exceeds the limit, into parts at token boundaries,
+// so that concatenating the parts reproduces the line exactly. Tokens ending within a character
+// that ends a part belong to that part.
+func (s *chunkSplitter) addLongLine(i int) {
+	line := s.lines[i]
+	offsets := s.lineOffsets[i]
+
+	sep := "\n"
+	start := 0
+	for first := 0; first < len(offsets); {
+		last := min(first+max(s.limit(), 1), len(offsets))
+		end := offsets[last-1]
+		if last == len(offsets) {
+			// Trailing characters of a token assigned to the next line belong to the last part.
+			end = len(line)
+		}
+		first = last
+		if end == start {
+			continue
+		}
+		s.parts = append(s.parts, splitPart{
+			content: line[start:end],
+			sep:     sep,
+			start:   i,
+			end:     i,
+		})
+		start = end
+		sep = ""
+	}
+}
+
+// funcSegments parses content as a function or method declaration and returns the segments
+// of its lines: the header up to the opening brace of the body, one segment per statement
+// of the body, and the closing brace. If the function has a doc comment, the header consists
+// of the doc comment and the signature. It returns nil if content is not a function declaration.
+func funcSegments(content string) []segment {
+	// The package clause is put on the first line so that line numbers match the content.
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", "package p;"+content, parser.SkipObjectResolution)
+	if err != nil || len(file.Decls) != 1 {
+		return nil
+	}
+	fn, ok := file.Decls[0].(*ast.FuncDecl)
+	if !ok || fn.Body == nil {
+		return nil
+	}
+
+	b := &segmentBuilder{fset: fset}
+	numLines := strings.Count(content, "\n") + 1
+	segments := b.blockSegments(0, numLines, fn.Body.Lbrace, stmtNodes(fn.Body.List))
+	// Lines before the func keyword belong to the doc comment.
+	header := &segments[0]
+	if sig := b.lineIndex(fn.Type.Pos()); sig > header.start {
+		header.children = []segment{
+			{start: header.start, end: sig},
+			{start: sig, end: header.end},
+		}
+	}
+	return segments
+}
+
+// segmentBuilder builds segments from the statements of a parsed function.
+type segmentBuilder struct {
+	fset *token.FileSet
+}
+
+// lineIndex returns the index of the line containing pos.
+func (b *segmentBuilder) lineIndex(pos token.Pos) int {
+	return b.fset.Position(pos).Line - 1
+}
+
+// blockSegments returns the segments of the lines in [start, end) of a construct whose body
+// opens at lbrace and contains the given statements: a header up to and including the line of
+// lbrace, a segment per statement including the comments and lines preceding it, and the
+// remaining lines after the last statement.
+func (b *segmentBuilder) blockSegments(start, end int, lbrace token.Pos, stmts []ast.Node) []segment {
+	headerEnd := min(b.lineIndex(lbrace)+1, end)
+	segments := []segment{{start: start, end: headerEnd}}
+
+	prev := headerEnd
+	for _, stmt := range stmts {
+		stmtEnd := min(b.lineIndex(stmt.End())+1, end)
+		// Statements sharing a line with the previous segment are part of it.
+		if stmtEnd <= prev {
+			continue
+		}
+		segments = append(segments, segment{
+			start:    prev,
+			end:      stmtEnd,
+			children: b.stmtSegments(stmt, prev, stmtEnd),
+		})
+		prev = stmtEnd
+	}
+	if prev < end {
+		segments = append(segments, segment{start: prev, end: end})
+	}
+	return segments
+}
+
+// stmtSegments returns the finer-grained segments of the lines in [start, end) containing stmt,
+// or nil if stmt has no nested block that it can be split at.
+func (b *segmentBuilder) stmtSegments(stmt ast.Node, start, end int) []segment {
+	switch s := stmt.(type) {
+	case *ast.BlockStmt:
+		return b.blockSegments(start, end, s.Lbrace, stmtNodes(s.List))
+	case *ast.IfStmt:
+		stmts := stmtNodes(s.Body.List)
+		switch e := s.Else.(type) {
+		case *ast.BlockStmt:
+			stmts = append(stmts, stmtNodes(e.List)...)
+		case *ast.IfStmt:
+			stmts = append(stmts, e)
+		}
+		return b.blockSegments(start, end, s.Body.Lbrace, stmts)
+	case *ast.ForStmt:
+		return b.blockSegments(start, end, s.Body.Lbrace, stmtNodes(s.Body.List))
+	case *ast.RangeStmt:
+		return b.blockSegments(start, end, s.Body.Lbrace, stmtNodes(s.Body.List))
+	case *ast.SwitchStmt:
+		return b.blockSegments(start, end, s.Body.Lbrace, stmtNodes(s.Body.List))
+	case *ast.TypeSwitchStmt:
+		return b.blockSegments(start, end, s.Body.Lbrace, stmtNodes(s.Body.List))
+	case *ast.SelectStmt:
+		return b.blockSegments(start, end, s.Body.Lbrace, stmtNodes(s.Body.List))
+	case *ast.CaseClause:
+		return b.blockSegments(start, end, s.Colon, stmtNodes(s.Body))
+	case *ast.CommClause:
+		return b.blockSegments(start, end, s.Colon, stmtNodes(s.Body))
+	case *ast.LabeledStmt:
+		return b.stmtSegments(s.Stmt, start, end)
+	}
+
+	// Statements such as t.Run("name", func(t *testing.T) { ... }) are split
+	// at the statements of the outermost function literal they contain.
+	var lit *ast.FuncLit
+	ast.Inspect(stmt, func(n ast.Node) bool {
+		if f, ok := n.(*ast.FuncLit); ok && lit == nil {
+			lit = f
+		}
+		return lit == nil
+	})
+	if lit != nil {
+		return b.blockSegments(start, end, lit.Body.Lbrace, stmtNodes(lit.Body.List))
+	}
+	return nil
+}
+
+func stmtNodes(stmts []ast.Stmt) []ast.Node {
+	nodes := make([]ast.Node, 0, len(stmts))
+	for _, stmt := range stmts {
+		nodes = append(nodes, stmt)
+	}
+	return nodes
+}
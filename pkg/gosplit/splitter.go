@@ -0,0 +1,540 @@
+// Package gosplit splits Go source code files into chunks, where each chunk contains a function,
+// type definition, method, constant, or variable. The chunks are intended to be used with
+// embedding models.
+//
+// A Splitter is created with New and configured with functional options:
+//
+//	s, err := gosplit.New(gosplit.WithChunkSize(512), gosplit.WithChunkTypes(gosplit.ChunkTypeFunction))
+//	if err != nil {
+//		return err
+//	}
+//	chunks, err := s.SplitDir("./internal")
+//
+// Every Split method has a Seq variant that returns an iterator yielding the chunks as the
+// declarations are processed, instead of collecting them:
+//
+//	for chunk, err := range s.SplitDirSeq("./internal") {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+package gosplit
+
+import (
+	"fmt"
+	"go/token"
+	"iter"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Splitter splits Go source code into chunks. It is safe for concurrent use if its tokenizer is,
+// which is the case for the tokenizers of this package.
+type Splitter struct {
+	tokenizer Tokenizer
+	chunkSize int
+	split     splitOptions
+	root      string
+	filters   []func(*Chunk) bool
+	jobs      int
+	context   ContextMode
+	callers   bool
+	typeCheck bool
+	build     BuildContext
+}
+
+// Option configures a Splitter.
+type Option func(*Splitter)
+
+// WithTokenizer sets the tokenizer used to count the tokens of chunks.
+// The default is the DefaultTokenizer encoding.
+func WithTokenizer(tok Tokenizer) Option {
+	return func(s *Splitter) { s.tokenizer = tok }
+}
+
+// WithChunkSize sets the maximum number of tokens per chunk. Chunks exceeding it are split
+// into parts. The default of 0 means no limit.
+func WithChunkSize(n int) Option {
+	return func(s *Splitter) { s.chunkSize = n }
+}
+
+// WithChunkOverlap sets the number of trailing tokens of every split part that are repeated
+// at the start of the next part. It must be smaller than the chunk size.
+func WithChunkOverlap(n int) Option {
+	return func(s *Splitter) { s.split.Overlap = n }
+}
+
+// WithRepeatHeader makes every part of a split function or method after the first start with
+// its declaration header.
+func WithRepeatHeader(repeat bool) Option {
+	return func(s *Splitter) { s.split.RepeatHeader = repeat }
+}
+
+// WithRoot makes the paths recorded in chunks relative to the root directory.
+// By default, paths are recorded as they are given.
+func WithRoot(root string) Option {
+	return func(s *Splitter) { s.root = root }
+}
+
+// WithFilter keeps only the chunks for which keep returns true. The filter sees every chunk
+// before it is split, with its path, ID and size set. Several filters must all be satisfied.
+func WithFilter(keep func(*Chunk) bool) Option {
+	return func(s *Splitter) { s.filters = append(s.filters, keep) }
+}
+
+// WithChunkTypes keeps only the chunks of the given types.
+func WithChunkTypes(types ...ChunkType) Option {
+	keep := make(map[ChunkType]bool, len(types))
+	for _, t := range types {
+		keep[t] = true
+	}
+	return WithFilter(func(c *Chunk) bool { return keep[c.Type] })
+}
+
+// WithContext selects whether and how the file context of chunks, i.e. the package clause
+// and the imports they reference, is included. The default is ContextNone.
+func WithContext(mode ContextMode) Option {
+	return func(s *Splitter) { s.context = mode }
+}
+
+// WithJobs sets the number of files that SplitFiles and SplitDir process concurrently.
+// The chunks are yielded in the same order regardless of the number of jobs. The default is 1.
+func WithJobs(n int) Option {
+	return func(s *Splitter) { s.jobs = n }
+}
+
+// WithCallers makes SplitFiles and SplitDir record in the CalledBy field of function and method
+// chunks the IDs of the chunks calling them among all chunks they split. Callers are linked
+// before chunks exceeding the chunk size are split: a split caller is recorded once by the ID of
+// the original chunk, which is the ParentID of its parts, and only the first part of a split
+// function or method records its callers. Since a function may be called by a file after its own,
+// the Seq variants yield the first chunk only after all files have been split.
+func WithCallers(callers bool) Option {
+	return func(s *Splitter) { s.callers = callers }
+}
+
+// WithTypeCheck makes the splitter type-check the Go packages of the files with go/types, so that
+// chunks record the Signature and ReceiverType of functions and methods and the References of all
+// chunks, and the callers recorded with WithCallers are matched by the resolved functions and
+// methods. SplitFiles and SplitDir check the files of a directory together as one package per
+// package name, and the other Split methods check a single file as a package of its own.
+// Dependencies are imported from source as the go command resolves them from the directory of
+// a file; those that cannot be imported are skipped, and so is everything depending on them.
+// Packages are checked one at a time regardless of WithJobs.
+func WithTypeCheck(typeCheck bool) Option {
+	return func(s *Splitter) { s.typeCheck = typeCheck }
+}
+
+// WithBuildContext sets the build tags, GOOS and GOARCH for which WithTypeCheck imports the
+// dependencies of packages and resolves the import paths of the directories of files without one.
+// The default is the configuration of the host.
+func WithBuildContext(bc BuildContext) Option {
+	return func(s *Splitter) { s.build = bc }
+}
+
+// New returns a Splitter configured with the given options.
+func New(opts ...Option) (*Splitter, error) {
+	s := &Splitter{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.tokenizer == nil {
+		tok, err := NewTokenizer(DefaultTokenizer, "")
+		if err != nil {
+			return nil, err
+		}
+		s.tokenizer = tok
+	}
+	if s.split.Overlap < 0 || (s.split.Overlap > 0 && s.split.Overlap >= s.chunkSize) {
+		return nil, fmt.Errorf("chunk overlap must be non-negative and smaller than the chunk size")
+	}
+	if s.context == "" {
+		s.context = ContextNone
+	}
+	if !slices.Contains(ContextModes(), s.context) {
+		return nil, fmt.Errorf("unknown context mode %q", s.context)
+	}
+	return s, nil
+}
+
+// Tokenizer returns the tokenizer used to count the tokens of chunks.
+func (s *Splitter) Tokenizer() Tokenizer {
+	return s.tokenizer
+}
+
+// Path returns the path recorded in the chunks of the file at path.
+func (s *Splitter) Path(path string) (string, error) {
+	return chunkPath(s.root, path)
+}
+
+// SplitSource splits the Go source code src of the named file into chunks.
+func (s *Splitter) SplitSource(name string, src []byte) ([]*Chunk, error) {
+	return collect(s.SplitSourceSeq(name, src))
+}
+
+// SplitSourceSeq returns an iterator over the chunks of the Go source code src of the named file.
+func (s *Splitter) SplitSourceSeq(name string, src []byte) iter.Seq2[*Chunk, error] {
+	return s.SplitFileSourceSeq(File{Path: name}, src)
+}
+
+// SplitFileSource splits the Go source code src of the given file into chunks.
+// Unlike SplitSource, the chunks record the import path of the file.
+func (s *Splitter) SplitFileSource(file File, src []byte) ([]*Chunk, error) {
+	return collect(s.SplitFileSourceSeq(file, src))
+}
+
+// SplitFileSourceSeq returns an iterator over the chunks of the Go source code src of the given file.
+func (s *Splitter) SplitFileSourceSeq(file File, src []byte) iter.Seq2[*Chunk, error] {
+	return func(yield func(*Chunk, error) bool) {
+		parsed, err := processSource(file.Path, src)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		s.yieldChunks(parsed, file, yield)
+	}
+}
+
+// SplitFile reads the Go source file at path and splits it into chunks.
+func (s *Splitter) SplitFile(path string) ([]*Chunk, error) {
+	return collect(s.SplitFileSeq(path))
+}
+
+// SplitFileSeq returns an iterator over the chunks of the Go source file at path.
+func (s *Splitter) SplitFileSeq(path string) iter.Seq2[*Chunk, error] {
+	return func(yield func(*Chunk, error) bool) {
+		parsed, err := processFile(path)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		s.yieldChunks(parsed, File{Path: path}, yield)
+	}
+}
+
+// SplitFiles splits the given files in order.
+func (s *Splitter) SplitFiles(files []File) ([]*Chunk, error) {
+	return collect(s.SplitFilesSeq(files))
+}
+
+// SplitFilesSeq returns an iterator over the chunks of the given files in order.
+// Each file is only read when the iteration reaches it, or, with several jobs, shortly before,
+// unless the callers of chunks are recorded.
+func (s *Splitter) SplitFilesSeq(files []File) iter.Seq2[*Chunk, error] {
+	if !s.callers {
+		return s.splitFilesSeq(files)
+	}
+	return func(yield func(*Chunk, error) bool) {
+		whole := *s
+		whole.chunkSize = 0
+		chunks, err := collect(whole.splitFilesSeq(files))
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		linkCallers(chunks)
+		for _, chunk := range chunks {
+			parts, err := s.SplitChunk(chunk)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for _, part := range parts {
+				if !yield(part, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// splitFilesSeq returns an iterator over the chunks of the given files in order.
+func (s *Splitter) splitFilesSeq(files []File) iter.Seq2[*Chunk, error] {
+	if s.typeCheck {
+		return s.splitTypeCheckedFilesSeq(files)
+	}
+	if s.jobs > 1 {
+		return s.splitFilesConcurrently(files)
+	}
+	return func(yield func(*Chunk, error) bool) {
+		for _, file := range files {
+			for chunk, err := range s.readFileSeq(file) {
+				if !yield(chunk, err) || err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// readFileSeq reads the given file and returns an iterator over its chunks.
+func (s *Splitter) readFileSeq(file File) iter.Seq2[*Chunk, error] {
+	return func(yield func(*Chunk, error) bool) {
+		src, err := os.ReadFile(filepath.Clean(file.Path))
+		if err != nil {
+			yield(nil, fmt.Errorf("error reading file %s: %v", file.Path, err))
+			return
+		}
+		for chunk, err := range s.SplitFileSourceSeq(file, src) {
+			if err != nil {
+				yield(nil, fmt.Errorf("error processing file %s: %v", file.Path, err))
+				return
+			}
+			if !yield(chunk, nil) {
+				return
+			}
+		}
+	}
+}
+
+// fileResult holds the chunks of a file split by a worker of splitFilesConcurrently.
+type fileResult struct {
+	chunks []*Chunk
+	err    error
+}
+
+// splitFilesConcurrently returns an iterator over the chunks of the given files in order,
+// splitting up to s.jobs files at a time.
+func (s *Splitter) splitFilesConcurrently(files []File) iter.Seq2[*Chunk, error] {
+	return func(yield func(*Chunk, error) bool) {
+		// The results are queued in the order of the files, so that the chunks are yielded in
+		// the same order as by a sequential run. The semaphore bounds the number of files being
+		// split, and the queue the number of split files waiting to be yielded.
+		results := make(chan chan fileResult, s.jobs)
+		sem := make(chan struct{}, s.jobs)
+		done := make(chan struct{})
+		defer close(done)
+
+		go func() {
+			defer close(results)
+			for _, file := range files {
+				select {
+				case sem <- struct{}{}:
+				case <-done:
+					return
+				}
+				result := make(chan fileResult, 1)
+				go func() {
+					defer func() { <-sem }()
+					chunks, err := collect(s.readFileSeq(file))
+					result <- fileResult{chunks: chunks, err: err}
+				}()
+				select {
+				case results <- result:
+				case <-done:
+					return
+				}
+			}
+		}()
+
+		for result := range results {
+			r := <-result
+			if r.err != nil {
+				yield(nil, r.err)
+				return
+			}
+			for _, chunk := range r.chunks {
+				if !yield(chunk, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// splitTypeCheckedFilesSeq returns an iterator over the chunks of the given files in order.
+// When the iteration reaches a file, the files of its directory are parsed and type-checked.
+func (s *Splitter) splitTypeCheckedFilesSeq(files []File) iter.Seq2[*Chunk, error] {
+	return func(yield func(*Chunk, error) bool) {
+		checker := newTypeChecker(s.build)
+		parsed := make(map[File]*parsedFile)
+		for _, file := range files {
+			if _, ok := parsed[file]; !ok {
+				if err := checkDir(checker, files, filepath.Dir(file.Path), parsed); err != nil {
+					yield(nil, err)
+					return
+				}
+			}
+
+			ok := true
+			s.yieldChunks(parsed[file], file, func(chunk *Chunk, err error) bool {
+				if err != nil {
+					err = fmt.Errorf("error processing file %s: %v", file.Path, err)
+				}
+				ok = yield(chunk, err) && err == nil
+				return ok
+			})
+			if !ok {
+				return
+			}
+			delete(parsed, file)
+		}
+	}
+}
+
+// checkDir parses the files among files in dir that are not parsed yet, type-checks them as one
+// package per import path and package name, and adds them to parsed.
+func checkDir(checker *typeChecker, files []File, dir string, parsed map[File]*parsedFile) error {
+	type pkgKey struct {
+		path string
+		name string
+	}
+	var keys []pkgKey
+	packages := make(map[pkgKey][]*parsedFile)
+	fset := token.NewFileSet()
+	for _, file := range files {
+		if _, ok := parsed[file]; ok || filepath.Dir(file.Path) != dir {
+			continue
+		}
+		src, err := os.ReadFile(filepath.Clean(file.Path))
+		if err != nil {
+			return fmt.Errorf("error reading file %s: %v", file.Path, err)
+		}
+		p, err := parseSource(fset, file.Path, src)
+		if err != nil {
+			return fmt.Errorf("error processing file %s: %v", file.Path, err)
+		}
+		parsed[file] = p
+
+		key := pkgKey{path: checker.packagePath(file), name: p.file.Name.Name}
+		if _, ok := packages[key]; !ok {
+			keys = append(keys, key)
+		}
+		packages[key] = append(packages[key], p)
+	}
+
+	for _, key := range keys {
+		checker.check(key.path, packages[key])
+	}
+	return nil
+}
+
+// SplitDir splits the Go source files in dir and all of its subdirectories, skipping the
+// directories reported by SkipDir, in the order of their paths.
+func (s *Splitter) SplitDir(dir string) ([]*Chunk, error) {
+	return collect(s.SplitDirSeq(dir))
+}
+
+// SplitDirSeq returns an iterator over the chunks of the Go source files in dir and all of
+// its subdirectories, in the same order as SplitDir.
+func (s *Splitter) SplitDirSeq(dir string) iter.Seq2[*Chunk, error] {
+	return func(yield func(*Chunk, error) bool) {
+		paths, err := walkDir(dir)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		files := make([]File, 0, len(paths))
+		for _, path := range paths {
+			files = append(files, File{Path: path})
+		}
+		for chunk, err := range s.SplitFilesSeq(files) {
+			if !yield(chunk, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// SplitChunk splits a chunk exceeding the chunk size into parts. Other chunks are returned as is.
+// The file context prepended to chunks produced with ContextPrefix is prepended to every part.
+func (s *Splitter) SplitChunk(chunk *Chunk) ([]*Chunk, error) {
+	if s.chunkSize <= 0 {
+		return []*Chunk{chunk}, nil
+	}
+	if chunk.prefix != "" {
+		return s.splitPrefixedChunk(chunk)
+	}
+	parts, err := splitChunk(chunk, s.tokenizer, s.chunkSize, s.split)
+	if err != nil {
+		return nil, fmt.Errorf("error splitting chunk: %v", err)
+	}
+	return parts, nil
+}
+
+// yieldChunks sets the path, import path, file context, type information, callees, ID and size
+// of the chunks of a parsed file, drops the chunks rejected by the filters, and yields the chunks,
+// split into parts if they exceed the chunk size. It stops at the first error, which is yielded.
+func (s *Splitter) yieldChunks(parsed *parsedFile, file File, yield func(*Chunk, error) bool) {
+	path, err := s.Path(file.Path)
+	if err != nil {
+		yield(nil, err)
+		return
+	}
+
+	if s.typeCheck && parsed.info == nil {
+		// A file split on its own is type-checked as a package of its own.
+		checker := newTypeChecker(s.build)
+		checker.check(checker.packagePath(file), []*parsedFile{parsed})
+	}
+
+	for chunk, node := range parsed.chunks() {
+		chunk.Path = path
+		chunk.ImportPath = file.ImportPath
+		chunk.Tokenizer = s.tokenizer.Name()
+		var context string
+		if s.context != ContextNone {
+			context = parsed.context(node)
+		}
+		if s.context == ContextField {
+			chunk.Context = context
+		}
+		if parsed.info != nil {
+			parsed.resolve(chunk, node)
+		}
+		if s.callers {
+			chunk.callees = parsed.callees(node)
+		}
+		setChunkID(chunk)
+
+		tokenCount, err := s.tokenizer.Count(chunk.Content)
+		if err != nil {
+			yield(nil, fmt.Errorf("error counting tokens: %v", err))
+			return
+		}
+		chunk.Size = tokenCount
+		if s.context == ContextPrefix {
+			if err := s.prefixContext(chunk, context); err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+
+		if !s.keep(chunk) {
+			continue
+		}
+		parts, err := s.SplitChunk(chunk)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		for _, part := range parts {
+			if !yield(part, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (s *Splitter) keep(chunk *Chunk) bool {
+	for _, keep := range s.filters {
+		if !keep(chunk) {
+			return false
+		}
+	}
+	return true
+}
+
+// collect returns the chunks yielded by seq, or the first error.
+func collect(seq iter.Seq2[*Chunk, error]) ([]*Chunk, error) {
+	var chunks []*Chunk
+	for chunk, err := range seq {
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
@@ -0,0 +1,36 @@
+package testdata
+
+import "fmt"
+
+// Number is a constraint satisfied by numeric types.
+type Number interface {
+	~int | ~int64 | ~float64
+}
+
+// List is a generic linked list.
+type List[T any] struct {
+	head *node[T]
+}
+
+// Push adds v to the front of the list.
+func (l *List[T]) Push(v T) {
+	l.head = &node[T]{value: v, next: l.head}
+}
+
+type Pair[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+func (p Pair[K, V]) String() string {
+	return fmt.Sprint(p.Key, p.Value)
+}
+
+// Sum returns the sum of the values.
+func Sum[S ~[]E, E Number](values S) E {
+	var sum E
+	for _, v := range values {
+		sum += v
+	}
+	return sum
+}
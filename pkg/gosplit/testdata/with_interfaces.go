@@ -0,0 +1,22 @@
+package testdata
+
+import "io"
+
+// Store persists key-value pairs.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the value stored under key.
+	Get(key string) ([]byte, error)
+	// Put stores value under key.
+	Put(key string, value []byte) error
+}
+
+// ReadStore is a Store that can also be read as a stream.
+type ReadStore interface {
+	Store
+	io.ReadCloser
+
+	Len() int
+}
+
+type Empty interface{}
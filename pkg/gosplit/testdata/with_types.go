@@ -0,0 +1,24 @@
+package testdata
+
+import "net/http"
+
+// Status is the state of a job.
+type Status int
+
+// HandlerFunc handles a request.
+type HandlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+type Labels map[string]string
+
+// Handler is an alias kept for compatibility.
+type Handler = http.Handler
+
+// Identifiers used by the scheduler.
+type (
+	JobID string // Unique within a scheduler
+
+	// Worker runs jobs.
+	Worker struct {
+		ID string
+	}
+)
@@ -0,0 +1,185 @@
+package gosplit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// DefaultTokenizer is the name of the tokenizer used if none is selected.
+const DefaultTokenizer = "cl100k_base"
+
+// Tokenizer splits text into the tokens seen by an embedding model.
+type Tokenizer interface {
+	// Name returns the name by which the tokenizer is selected and recorded in chunks.
+	Name() string
+	// Count returns the number of tokens in text.
+	Count(text string) (int, error)
+	// Offsets returns the byte offsets in text at which each of its tokens ends.
+	// The number of offsets is the number of tokens, and the last offset is len(text)
+	// unless text has no tokens. Offsets are at character boundaries, so that text can be cut at
+	// any of them: a token ending within a multi-byte character ends with the character instead,
+	// so that the offsets of the tokens of one character are the same.
+	Offsets(text string) ([]int, error)
+}
+
+// Names of the tokenizers that do not use a vocabulary.
+const (
+	tokenizerBytes = "bytes" // Every byte is a token
+	tokenizerChars = "chars" // Every Unicode character is a token
+	tokenizerWords = "words" // Every run of non-space characters is a token
+)
+
+// tiktokenEncodings lists the encodings of the tiktoken library.
+func tiktokenEncodings() []string {
+	return []string{
+		tiktoken.MODEL_CL100K_BASE,
+		tiktoken.MODEL_O200K_BASE,
+		tiktoken.MODEL_P50K_BASE,
+		tiktoken.MODEL_P50K_EDIT,
+		tiktoken.MODEL_R50K_BASE,
+	}
+}
+
+// TokenizerNames returns the names of the available tokenizers in sorted order.
+func TokenizerNames() []string {
+	names := append(tiktokenEncodings(), tokenizerBytes, tokenizerChars, tokenizerWords)
+	sort.Strings(names)
+	return names
+}
+
+// NewTokenizer returns the tokenizer with the given name: one of the tiktoken encodings
+// (e.g. "cl100k_base", "o200k_base"), "bytes", "chars", or "words". The BPE ranks of tiktoken
+// encodings are read from bpeFile if it is not empty; see bpeLoader for where they are found otherwise.
+// It never accesses the network, and fails if the ranks are not available.
+func NewTokenizer(name, bpeFile string) (Tokenizer, error) {
+	for _, encoding := range tiktokenEncodings() {
+		if name == encoding {
+			enc, err := getEncoding(name, bpeFile)
+			if err != nil {
+				return nil, err
+			}
+			return tiktokenTokenizer{name: name, encoding: enc}, nil
+		}
+	}
+
+	if bpeFile != "" {
+		return nil, fmt.Errorf("a BPE file can only be used with a tiktoken encoding, not %q", name)
+	}
+	switch name {
+	case tokenizerBytes:
+		return bytesTokenizer{}, nil
+	case tokenizerChars:
+		return charsTokenizer{}, nil
+	case tokenizerWords:
+		return wordsTokenizer{}, nil
+	}
+	return nil, fmt.Errorf("unknown tokenizer %q (available: %s)", name, strings.Join(TokenizerNames(), ", "))
+}
+
+// tiktokenTokenizer counts tokens with an encoding of the tiktoken library.
+type tiktokenTokenizer struct {
+	name     string
+	encoding *tiktoken.Tiktoken
+}
+
+func (t tiktokenTokenizer) Name() string { return t.name }
+
+func (t tiktokenTokenizer) Count(text string) (int, error) {
+	return len(t.encoding.Encode(text, nil, nil)), nil
+}
+
+func (t tiktokenTokenizer) Offsets(text string) ([]int, error) {
+	tokens := t.encoding.Encode(text, nil, nil)
+	offsets := make([]int, len(tokens))
+	offset := 0
+	for i, token := range tokens {
+		offset += len(t.encoding.Decode([]int{token}))
+		offsets[i] = offset
+	}
+	return runeBoundaries(text, offsets), nil
+}
+
+// bytesTokenizer counts every byte as a token.
+type bytesTokenizer struct{}
+
+func (bytesTokenizer) Name() string { return tokenizerBytes }
+
+func (bytesTokenizer) Count(text string) (int, error) { return len(text), nil }
+
+func (bytesTokenizer) Offsets(text string) ([]int, error) {
+	offsets := make([]int, len(text))
+	for i := range offsets {
+		offsets[i] = i + 1
+	}
+	return runeBoundaries(text, offsets), nil
+}
+
+// charsTokenizer counts every Unicode character as a token.
+type charsTokenizer struct{}
+
+func (charsTokenizer) Name() string { return tokenizerChars }
+
+func (charsTokenizer) Count(text string) (int, error) { return utf8.RuneCountInString(text), nil }
+
+func (charsTokenizer) Offsets(text string) ([]int, error) {
+	offsets := make([]int, 0, len(text))
+	for i, r := range text {
+		offsets = append(offsets, i+utf8.RuneLen(r))
+	}
+	return offsets, nil
+}
+
+// wordsTokenizer counts every run of non-space characters as a token.
+// The spaces between words belong to the following word, and trailing spaces to the last word.
+type wordsTokenizer struct{}
+
+func (wordsTokenizer) Name() string { return tokenizerWords }
+
+func (wordsTokenizer) Count(text string) (int, error) { return len(strings.Fields(text)), nil }
+
+func (wordsTokenizer) Offsets(text string) ([]int, error) {
+	var offsets []int
+	inWord := false
+	for i, r := range text {
+		space := unicode.IsSpace(r)
+		if inWord && space {
+			offsets = append(offsets, i)
+		}
+		inWord = !space
+	}
+	if inWord {
+		offsets = append(offsets, len(text))
+	} else if len(offsets) > 0 {
+		offsets[len(offsets)-1] = len(text)
+	}
+	return offsets, nil
+}
+
+// runeBoundaries moves the byte offsets in text that are within a multi-byte character to the end
+// of the character, and returns them.
+func runeBoundaries(text string, offsets []int) []int {
+	for i, offset := range offsets {
+		for offset < len(text) && !utf8.RuneStart(text[offset]) {
+			offset++
+		}
+		offsets[i] = offset
+	}
+	return offsets
+}
+
+// tokenSuffix returns the longest suffix of text that consists of at most n tokens.
+func tokenSuffix(tok Tokenizer, text string, n int) (string, error) {
+	offsets, err := tok.Offsets(text)
+	if err != nil {
+		return "", err
+	}
+	if n >= len(offsets) {
+		return text, nil
+	}
+	return text[offsets[len(offsets)-n-1]:], nil
+}
@@ -0,0 +1,216 @@
+package gosplit
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+)
+
+// typeChecker type-checks the packages of parsed files, importing their dependencies from
+// source for a build context. The imported packages and the import paths of directories are
+// shared by all packages it checks. It is not safe for concurrent use.
+type typeChecker struct {
+	bc       BuildContext
+	importer *sourceImporter
+	dirPaths map[string]string
+}
+
+// newTypeChecker returns a type checker for the build context.
+func newTypeChecker(bc BuildContext) *typeChecker {
+	return &typeChecker{
+		bc:       bc,
+		importer: newSourceImporter(bc),
+		dirPaths: make(map[string]string),
+	}
+}
+
+// check type-checks the files of a package, which must have been parsed into the same file set,
+// and records the type information in them. Errors, such as dependencies that cannot be imported,
+// are ignored, so that the information is recorded for everything that can be resolved despite them.
+func (c *typeChecker) check(path string, files []*parsedFile) {
+	info := &types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+		Defs:  make(map[*ast.Ident]types.Object),
+		Uses:  make(map[*ast.Ident]types.Object),
+	}
+	conf := types.Config{
+		Importer:    c.importer,
+		FakeImportC: true,
+		Error:       func(error) {},
+	}
+	syntax := make([]*ast.File, 0, len(files))
+	for _, file := range files {
+		syntax = append(syntax, file.file)
+	}
+	_, _ = conf.Check(path, files[0].fset, syntax, info)
+	for _, file := range files {
+		file.info = info
+	}
+}
+
+// packagePath returns the path the package of file is type-checked as: its import path if it is
+// known, or the import path the go command resolves for its directory, so that the package has
+// the same path as when it is imported by other packages. The directory is used as the path of
+// packages outside of modules.
+func (c *typeChecker) packagePath(file File) string {
+	if file.ImportPath != "" {
+		return file.ImportPath
+	}
+	dir := filepath.Dir(file.Path)
+	path, ok := c.dirPaths[dir]
+	if !ok {
+		if path = dirImportPath(dir, c.bc); path == "" {
+			path = filepath.ToSlash(dir)
+		}
+		c.dirPaths[dir] = path
+	}
+	return path
+}
+
+// sourceImporter imports packages from source like the "source" importer of go/importer,
+// but for a build context instead of the default one. Function bodies are not checked, and errors
+// within imported packages are ignored, so that everything they declare is available despite them.
+type sourceImporter struct {
+	ctxt     build.Context
+	fset     *token.FileSet
+	packages map[string]*types.Package // The imported packages by import path, nil while importing
+}
+
+// newSourceImporter returns an importer for the build tags, GOOS and GOARCH of the build context.
+func newSourceImporter(bc BuildContext) *sourceImporter {
+	ctxt := build.Default
+	ctxt.BuildTags = bc.Tags
+	if (bc.GOOS != "" && bc.GOOS != ctxt.GOOS) || (bc.GOARCH != "" && bc.GOARCH != ctxt.GOARCH) {
+		// Like the go command, cgo is disabled when cross-compiling unless enabled explicitly.
+		ctxt.CgoEnabled = os.Getenv("CGO_ENABLED") == "1"
+	}
+	if bc.GOOS != "" {
+		ctxt.GOOS = bc.GOOS
+	}
+	if bc.GOARCH != "" {
+		ctxt.GOARCH = bc.GOARCH
+	}
+	return &sourceImporter{ctxt: ctxt, fset: token.NewFileSet(), packages: make(map[string]*types.Package)}
+}
+
+// Import imports the package with the given import path as resolved from the current directory.
+func (p *sourceImporter) Import(path string) (*types.Package, error) {
+	return p.ImportFrom(path, ".", 0)
+}
+
+// ImportFrom imports the package with the given import path as resolved from dir, which is the
+// directory of the importing file.
+func (p *sourceImporter) ImportFrom(path, dir string, _ types.ImportMode) (*types.Package, error) {
+	if path == "unsafe" {
+		return types.Unsafe, nil
+	}
+	// In module mode, the go command is run in the directory, which must be absolute, so that
+	// the path is resolved within the module of the importing file.
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving directory: %v", err)
+	}
+	ctxt := p.ctxt
+	ctxt.Dir = dir
+	bp, err := ctxt.Import(path, dir, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error importing package %s: %v", path, err)
+	}
+	if pkg, ok := p.packages[bp.ImportPath]; ok {
+		if pkg == nil {
+			return nil, fmt.Errorf("import cycle through package %s", bp.ImportPath)
+		}
+		return pkg, nil
+	}
+
+	var files []*ast.File
+	for _, name := range append(bp.GoFiles, bp.CgoFiles...) {
+		file, err := parser.ParseFile(p.fset, filepath.Join(bp.Dir, name), nil, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing package %s: %v", bp.ImportPath, err)
+		}
+		files = append(files, file)
+	}
+	p.packages[bp.ImportPath] = nil
+	conf := types.Config{
+		Importer:         p,
+		FakeImportC:      true,
+		IgnoreFuncBodies: true,
+		Error:            func(error) {},
+	}
+	pkg, _ := conf.Check(bp.ImportPath, p.fset, files, nil)
+	p.packages[bp.ImportPath] = pkg
+	return pkg, nil
+}
+
+// resolve sets the fields of chunk that are resolved by type checking from the declaration node.
+// Signatures and receiver types involving types that could not be resolved are left out.
+func (f *parsedFile) resolve(chunk *Chunk, node ast.Node) {
+	chunk.References = f.references(node)
+
+	decl, ok := node.(*ast.FuncDecl)
+	if !ok {
+		return
+	}
+	fn, ok := f.info.Defs[decl.Name].(*types.Func)
+	if !ok {
+		return
+	}
+	chunk.object = fn.FullName()
+	validRecv := decl.Recv == nil || f.validTypes(decl.Recv)
+	if recv := fn.Signature().Recv(); recv != nil && validRecv {
+		chunk.ReceiverType = types.TypeString(recv.Type(), nil)
+	}
+	if validRecv && f.validTypes(decl.Type) {
+		chunk.Signature = types.ObjectString(fn, nil)
+	}
+}
+
+// references returns the full names of the package-level objects and methods referenced within
+// node, e.g. "net/http.Handler", "fmt.Println" or "(*database/sql.DB).Query", in the order of
+// their first reference. Predeclared objects, local objects and struct fields are left out.
+func (f *parsedFile) references(node ast.Node) []string {
+	var refs []string
+	seen := make(map[string]bool)
+	ast.Inspect(node, func(n ast.Node) bool {
+		ident, ok := n.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		var name string
+		switch obj := f.info.Uses[ident].(type) {
+		case nil, *types.PkgName:
+		case *types.Func:
+			name = obj.Origin().FullName()
+		default:
+			if obj.Pkg() != nil && obj.Parent() == obj.Pkg().Scope() {
+				name = obj.Pkg().Path() + "." + obj.Name()
+			}
+		}
+		if name != "" && !seen[name] {
+			seen[name] = true
+			refs = append(refs, name)
+		}
+		return true
+	})
+	return refs
+}
+
+// validTypes reports whether all type expressions within node were resolved by type checking.
+func (f *parsedFile) validTypes(node ast.Node) bool {
+	valid := true
+	ast.Inspect(node, func(n ast.Node) bool {
+		if expr, ok := n.(ast.Expr); ok {
+			if tv, ok := f.info.Types[expr]; ok && tv.Type == types.Typ[types.Invalid] {
+				valid = false
+			}
+		}
+		return valid
+	})
+	return valid
+}